@@ -7,33 +7,52 @@
 package main
 
 import (
+	"context"
+	"errors"
 	"flag"
 	"fmt"
 	"log/slog"
 	"net/http"
-	_ "net/http/pprof"
+	"net/http/pprof"
 	"os"
+	"os/signal"
+	"syscall"
 	"time"
 
 	"github.com/prometheus/client_golang/prometheus/promhttp"
 	"go.uber.org/automaxprocs/maxprocs"
 
+	"github.com/blinklabs-io/cdnsd/internal/admin"
 	"github.com/blinklabs-io/cdnsd/internal/config"
 	"github.com/blinklabs-io/cdnsd/internal/dns"
 	"github.com/blinklabs-io/cdnsd/internal/indexer"
 	"github.com/blinklabs-io/cdnsd/internal/logging"
 	"github.com/blinklabs-io/cdnsd/internal/state"
+	"github.com/blinklabs-io/cdnsd/internal/supervisor"
 	"github.com/blinklabs-io/cdnsd/internal/version"
 )
 
+// Time allowed for HTTP servers to finish active requests on shutdown
+const httpShutdownTimeout = 10 * time.Second
+
 var cmdlineFlags struct {
-	configFile string
+	configFile       string
+	resetFingerprint bool
 }
 
 func slogPrintf(format string, v ...any) {
 	slog.Info(fmt.Sprintf(format, v...))
 }
 
+// handleReady reports whether the indexer is healthy, for use as a readiness probe
+func handleReady(w http.ResponseWriter, r *http.Request) {
+	if !indexer.GetIndexer().Healthy() {
+		http.Error(w, "indexer unhealthy", http.StatusServiceUnavailable)
+		return
+	}
+	fmt.Fprintln(w, "OK")
+}
+
 func main() {
 	flag.StringVar(
 		&cmdlineFlags.configFile,
@@ -41,6 +60,12 @@ func main() {
 		"",
 		"path to config file to load",
 	)
+	flag.BoolVar(
+		&cmdlineFlags.resetFingerprint,
+		"reset-fingerprint",
+		false,
+		"overwrite the config fingerprint in an existing state DB with the current config",
+	)
 	flag.Parse()
 
 	// Load config
@@ -49,6 +74,7 @@ func main() {
 		fmt.Printf("Failed to load config: %s\n", err)
 		os.Exit(1)
 	}
+	cfg.State.ResetFingerprint = cmdlineFlags.resetFingerprint
 
 	// Configure logger
 	logging.Configure()
@@ -67,6 +93,17 @@ func main() {
 		fmt.Sprintf("cdnsd %s started", version.GetVersionString()),
 	)
 
+	// Run self-test, which uses its own temporary state
+	if flag.Arg(0) == "selftest" {
+		if err := runSelftest(); err != nil {
+			slog.Error(
+				fmt.Sprintf("self-test failed: %s", err),
+			)
+			os.Exit(1)
+		}
+		return
+	}
+
 	// Load state
 	if err := state.GetState().Load(); err != nil {
 		slog.Error(
@@ -75,8 +112,37 @@ func main() {
 		os.Exit(1)
 	}
 
-	// Start debug listener
-	if cfg.Debug.ListenPort > 0 {
+	// Handle subcommands
+	switch flag.Arg(0) {
+	case "":
+	case "reindex":
+		if err := runReindex(flag.Args()[1:]); err != nil {
+			slog.Error(
+				fmt.Sprintf("failed to reindex: %s", err),
+			)
+			os.Exit(1)
+		}
+		if err := state.GetState().Close(); err != nil {
+			slog.Error(
+				fmt.Sprintf("failed to close state: %s", err),
+			)
+			os.Exit(1)
+		}
+		return
+	default:
+		slog.Error(
+			fmt.Sprintf("unknown subcommand: %s", flag.Arg(0)),
+		)
+		os.Exit(1)
+	}
+
+	// HTTP servers to shutdown on exit
+	var httpServers []*http.Server
+
+	// Start debug listener. It only serves pprof, so it's not started unless pprof is enabled
+	if cfg.Debug.ListenPort > 0 && !cfg.Debug.EnablePprof {
+		slog.Warn("not starting debug listener, pprof is disabled")
+	} else if cfg.Debug.ListenPort > 0 {
 		slog.Info(
 			fmt.Sprintf(
 				"starting debug listener on %s:%d",
@@ -84,22 +150,22 @@ func main() {
 				cfg.Debug.ListenPort,
 			),
 		)
-		go func() {
-			err := http.ListenAndServe(
-				fmt.Sprintf(
-					"%s:%d",
-					cfg.Debug.ListenAddress,
-					cfg.Debug.ListenPort,
-				),
-				nil,
-			)
-			if err != nil {
-				slog.Error(
-					fmt.Sprintf("failed to start debug listener: %s", err),
-				)
-				os.Exit(1)
-			}
-		}()
+		debugMux := http.NewServeMux()
+		debugMux.HandleFunc("/debug/pprof/", pprof.Index)
+		debugMux.HandleFunc("/debug/pprof/cmdline", pprof.Cmdline)
+		debugMux.HandleFunc("/debug/pprof/profile", pprof.Profile)
+		debugMux.HandleFunc("/debug/pprof/symbol", pprof.Symbol)
+		debugMux.HandleFunc("/debug/pprof/trace", pprof.Trace)
+		debugSrv := &http.Server{
+			Addr: fmt.Sprintf(
+				"%s:%d",
+				cfg.Debug.ListenAddress,
+				cfg.Debug.ListenPort,
+			),
+			Handler: debugMux,
+		}
+		httpServers = append(httpServers, debugSrv)
+		supervisor.Run("debug listener", serveHttp(debugSrv))
 	}
 
 	// Start metrics listener
@@ -117,20 +183,28 @@ func main() {
 		)
 		metricsMux := http.NewServeMux()
 		metricsMux.Handle("/metrics", promhttp.Handler())
+		metricsMux.HandleFunc("/ready", handleReady)
+		metricsMux.HandleFunc("/stats", handleStats)
 		metricsSrv := &http.Server{
 			Addr:         metricsListenAddr,
 			WriteTimeout: 10 * time.Second,
 			ReadTimeout:  10 * time.Second,
 			Handler:      metricsMux,
 		}
-		go func() {
-			if err := metricsSrv.ListenAndServe(); err != nil {
-				slog.Error(
-					fmt.Sprintf("failed to start metrics listener: %s", err),
-				)
-				os.Exit(1)
-			}
-		}()
+		httpServers = append(httpServers, metricsSrv)
+		supervisor.Run("metrics listener", serveHttp(metricsSrv))
+	}
+
+	// Start admin listener
+	if cfg.Admin.ListenPort > 0 {
+		adminSrv, err := admin.Start()
+		if err != nil {
+			slog.Error(
+				fmt.Sprintf("failed to start admin listener: %s", err),
+			)
+			os.Exit(1)
+		}
+		httpServers = append(httpServers, adminSrv)
 	}
 
 	// Start indexer
@@ -149,6 +223,51 @@ func main() {
 		os.Exit(1)
 	}
 
-	// Wait forever
-	select {}
+	// Wait for a signal to shutdown
+	sigCtx, sigCancel := signal.NotifyContext(
+		context.Background(),
+		syscall.SIGINT,
+		syscall.SIGTERM,
+	)
+	defer sigCancel()
+	<-sigCtx.Done()
+	slog.Info("shutting down")
+	shutdownHttpServers(httpServers)
+	if err := state.GetState().Close(); err != nil {
+		slog.Error(
+			fmt.Sprintf("failed to close state: %s", err),
+		)
+		os.Exit(1)
+	}
+}
+
+// serveHttp returns a function that runs the HTTP server, for use with the supervisor. The server isn't
+// restarted after it's shut down
+func serveHttp(srv *http.Server) func() error {
+	return func() error {
+		if err := srv.ListenAndServe(); !errors.Is(err, http.ErrServerClosed) {
+			return err
+		}
+		return nil
+	}
+}
+
+// shutdownHttpServers gracefully stops the HTTP servers, waiting for active requests to finish
+func shutdownHttpServers(servers []*http.Server) {
+	ctx, cancel := context.WithTimeout(
+		context.Background(),
+		httpShutdownTimeout,
+	)
+	defer cancel()
+	for _, srv := range servers {
+		if err := srv.Shutdown(ctx); err != nil {
+			slog.Error(
+				fmt.Sprintf(
+					"failed to shutdown HTTP server on %s: %s",
+					srv.Addr,
+					err,
+				),
+			)
+		}
+	}
 }
@@ -0,0 +1,71 @@
+// Copyright 2024 Blink Labs Software
+//
+// Use of this source code is governed by an MIT-style
+// license that can be found in the LICENSE file or at
+// https://opensource.org/licenses/MIT.
+
+package main
+
+import (
+	"encoding/hex"
+	"errors"
+	"flag"
+	"fmt"
+	"log/slog"
+
+	"github.com/blinklabs-io/cdnsd/internal/state"
+)
+
+// runReindex resets the chainsync cursor so that the next start re-processes the chain from the specified point
+func runReindex(args []string) error {
+	var reindexFlags struct {
+		fromSlot     uint64
+		fromHash     string
+		clearRecords bool
+	}
+	fs := flag.NewFlagSet("reindex", flag.ExitOnError)
+	fs.Uint64Var(
+		&reindexFlags.fromSlot,
+		"from-slot",
+		0,
+		"slot number to resume indexing from",
+	)
+	fs.StringVar(
+		&reindexFlags.fromHash,
+		"from-hash",
+		"",
+		"block hash to resume indexing from",
+	)
+	fs.BoolVar(
+		&reindexFlags.clearRecords,
+		"clear-records",
+		false,
+		"remove all indexed domain records (discovered TLDs are kept)",
+	)
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+	if reindexFlags.fromSlot == 0 || reindexFlags.fromHash == "" {
+		return errors.New("both -from-slot and -from-hash must be specified")
+	}
+	if _, err := hex.DecodeString(reindexFlags.fromHash); err != nil {
+		return fmt.Errorf("invalid block hash: %w", err)
+	}
+	if reindexFlags.clearRecords {
+		if err := state.GetState().ClearDomainRecords(); err != nil {
+			return err
+		}
+		slog.Info("cleared indexed domain records")
+	}
+	if err := state.GetState().UpdateCursor(reindexFlags.fromSlot, reindexFlags.fromHash); err != nil {
+		return err
+	}
+	slog.Info(
+		fmt.Sprintf(
+			"reset chainsync cursor to %d.%s, indexing will resume from there on next start",
+			reindexFlags.fromSlot,
+			reindexFlags.fromHash,
+		),
+	)
+	return nil
+}
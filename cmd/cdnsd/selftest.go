@@ -0,0 +1,271 @@
+// Copyright 2024 Blink Labs Software
+//
+// Use of this source code is governed by an MIT-style
+// license that can be found in the LICENSE file or at
+// https://opensource.org/licenses/MIT.
+
+package main
+
+import (
+	"context"
+	"crypto/tls"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"net"
+	"time"
+
+	"github.com/miekg/dns"
+	"github.com/quic-go/quic-go"
+
+	"github.com/blinklabs-io/cdnsd/internal/config"
+	cdnsd_dns "github.com/blinklabs-io/cdnsd/internal/dns"
+	"github.com/blinklabs-io/cdnsd/internal/state"
+)
+
+const (
+	selftestDomain  = "selftest.cdnsd."
+	selftestAddress = "192.0.2.1"
+	// How long to keep retrying queries while the listeners start up
+	selftestTimeout = 5 * time.Second
+)
+
+// runSelftest starts the DNS listeners on local ephemeral ports with a temporary in-memory state
+// containing a known record, and queries it over each supported protocol to verify the full query path
+func runSelftest() error {
+	cfg := config.GetConfig()
+	resetSelftestPolicy(cfg)
+	// Use a temporary in-memory state
+	if err := state.GetState().LoadInMemory(); err != nil {
+		return fmt.Errorf("failed to load state: %w", err)
+	}
+	defer func() {
+		_ = state.GetState().Close()
+	}()
+	err := state.GetState().UpdateDomain(
+		selftestDomain,
+		[]state.DomainRecord{
+			{
+				Lhs:  selftestDomain,
+				Type: "A",
+				Ttl:  60,
+				Rhs:  selftestAddress,
+			},
+		},
+	)
+	if err != nil {
+		return fmt.Errorf("failed to seed state: %w", err)
+	}
+	// Build listeners on local ephemeral ports
+	port, err := freePort("tcp")
+	if err != nil {
+		return err
+	}
+	cfg.Dns.Listeners = []config.ListenerConfig{
+		{
+			Address:  "127.0.0.1",
+			Port:     port,
+			Protocol: config.ListenerProtocolUdp,
+		},
+		{
+			Address:  "127.0.0.1",
+			Port:     port,
+			Protocol: config.ListenerProtocolTcp,
+		},
+	}
+	checks := map[string]string{
+		"udp": net.JoinHostPort("127.0.0.1", fmt.Sprintf("%d", port)),
+		"tcp": net.JoinHostPort("127.0.0.1", fmt.Sprintf("%d", port)),
+	}
+	switch {
+	case cfg.Tls.CertFilePath == "" || cfg.Tls.KeyFilePath == "":
+		slog.Info("self-test: skipping TLS and QUIC, no TLS cert and key configured")
+	case cfg.Tls.ClientCaFilePath != "":
+		slog.Info("self-test: skipping TLS and QUIC, client certificates are required")
+	default:
+		tlsPort, err := freePort("tcp")
+		if err != nil {
+			return err
+		}
+		quicPort, err := freePort("udp")
+		if err != nil {
+			return err
+		}
+		cfg.Dns.Listeners = append(
+			cfg.Dns.Listeners,
+			config.ListenerConfig{
+				Address:  "127.0.0.1",
+				Port:     tlsPort,
+				Protocol: config.ListenerProtocolTls,
+			},
+			config.ListenerConfig{
+				Address:  "127.0.0.1",
+				Port:     quicPort,
+				Protocol: config.ListenerProtocolQuic,
+			},
+		)
+		checks["tcp-tls"] = net.JoinHostPort("127.0.0.1", fmt.Sprintf("%d", tlsPort))
+		checks["quic"] = net.JoinHostPort("127.0.0.1", fmt.Sprintf("%d", quicPort))
+	}
+	if err := cdnsd_dns.Start(); err != nil {
+		return fmt.Errorf("failed to start DNS listeners: %w", err)
+	}
+	var failed bool
+	for _, netType := range []string{"udp", "tcp", "tcp-tls", "quic"} {
+		address, ok := checks[netType]
+		if !ok {
+			continue
+		}
+		if err := selftestQuery(netType, address); err != nil {
+			slog.Error(
+				fmt.Sprintf("self-test: %s: %s", netType, err),
+			)
+			failed = true
+			continue
+		}
+		slog.Info(
+			fmt.Sprintf("self-test: %s: OK", netType),
+		)
+	}
+	if failed {
+		return errors.New("one or more checks failed")
+	}
+	slog.Info("self-test passed")
+	return nil
+}
+
+// resetSelftestPolicy resets config options that could change the answer for the seeded record, so that
+// the self-test only checks the build and listener config
+func resetSelftestPolicy(cfg *config.Config) {
+	cfg.Dns.Rpz = nil
+	cfg.Dns.AllowRecursionFrom = nil
+	cfg.Dns.ProxyProtocol = false
+	cfg.Dns.ProxyProtocolTrustedFrom = nil
+	cfg.Dns.TldAliases = nil
+	cfg.Dns.TldApexRecords = nil
+	cfg.Dns.CollisionPolicy = nil
+	cfg.Dns.MaxRecordsPerName = 0
+	cfg.Dns.FallbackHealthCheckInterval = 0
+	cfg.Indexer.RecordTypes = []string{"A"}
+}
+
+// selftestQuery queries for the seeded record and checks the answer
+func selftestQuery(netType string, address string) error {
+	client := &dns.Client{
+		Net:     netType,
+		Timeout: 1 * time.Second,
+		// We're connecting to ourselves, so the cert may not be valid for the local address
+		TLSConfig: &tls.Config{
+			InsecureSkipVerify: true,
+		},
+	}
+	msg := new(dns.Msg)
+	msg.SetQuestion(selftestDomain, dns.TypeA)
+	// Retry while the listener starts up
+	var resp *dns.Msg
+	var err error
+	deadline := time.Now().Add(selftestTimeout)
+	for {
+		if netType == "quic" {
+			resp, err = selftestQuicExchange(msg, address)
+		} else {
+			resp, _, err = client.Exchange(msg, address)
+		}
+		if err == nil || time.Now().After(deadline) {
+			break
+		}
+		time.Sleep(100 * time.Millisecond)
+	}
+	if err != nil {
+		return err
+	}
+	if resp.Rcode != dns.RcodeSuccess {
+		return fmt.Errorf(
+			"unexpected response code: %s",
+			dns.RcodeToString[resp.Rcode],
+		)
+	}
+	for _, answer := range resp.Answer {
+		if a, ok := answer.(*dns.A); ok && a.A.String() == selftestAddress {
+			return nil
+		}
+	}
+	return fmt.Errorf("expected A record not found in answer: %v", resp.Answer)
+}
+
+// selftestQuicExchange sends a query over DNS-over-QUIC (RFC 9250) and returns the response
+func selftestQuicExchange(msg *dns.Msg, address string) (*dns.Msg, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 1*time.Second)
+	defer cancel()
+	conn, err := quic.DialAddr(
+		ctx,
+		address,
+		&tls.Config{
+			// We're connecting to ourselves, so the cert may not be valid for the local address
+			InsecureSkipVerify: true,
+			NextProtos:         []string{"doq"},
+		},
+		nil,
+	)
+	if err != nil {
+		return nil, err
+	}
+	defer func() {
+		_ = conn.CloseWithError(0, "")
+	}()
+	stream, err := conn.OpenStreamSync(ctx)
+	if err != nil {
+		return nil, err
+	}
+	// The message ID must be 0 (RFC 9250 section 4.2.1)
+	query := msg.Copy()
+	query.Id = 0
+	queryBuf, err := query.Pack()
+	if err != nil {
+		return nil, err
+	}
+	// Each query is prefixed with a 2-byte length, and the stream is closed after sending it
+	if err := binary.Write(stream, binary.BigEndian, uint16(len(queryBuf))); err != nil {
+		return nil, err
+	}
+	if _, err := stream.Write(queryBuf); err != nil {
+		return nil, err
+	}
+	if err := stream.Close(); err != nil {
+		return nil, err
+	}
+	_ = stream.SetReadDeadline(time.Now().Add(1 * time.Second))
+	var respLen uint16
+	if err := binary.Read(stream, binary.BigEndian, &respLen); err != nil {
+		return nil, err
+	}
+	respBuf := make([]byte, respLen)
+	if _, err := io.ReadFull(stream, respBuf); err != nil {
+		return nil, err
+	}
+	resp := new(dns.Msg)
+	if err := resp.Unpack(respBuf); err != nil {
+		return nil, err
+	}
+	return resp, nil
+}
+
+// freePort returns a currently unused local port for the specified network ("tcp" or "udp")
+func freePort(network string) (uint, error) {
+	if network == "udp" {
+		conn, err := net.ListenPacket("udp", "127.0.0.1:0")
+		if err != nil {
+			return 0, err
+		}
+		defer conn.Close()
+		return uint(conn.LocalAddr().(*net.UDPAddr).Port), nil
+	}
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		return 0, err
+	}
+	defer listener.Close()
+	return uint(listener.Addr().(*net.TCPAddr).Port), nil
+}
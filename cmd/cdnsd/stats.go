@@ -0,0 +1,100 @@
+// Copyright 2024 Blink Labs Software
+//
+// Use of this source code is governed by an MIT-style
+// license that can be found in the LICENSE file or at
+// https://opensource.org/licenses/MIT.
+
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	dto "github.com/prometheus/client_model/go"
+
+	"github.com/blinklabs-io/cdnsd/internal/version"
+)
+
+// Prefixes of our own metrics included in the stats summary
+var statsMetricPrefixes = []string{
+	"dns_",
+	"indexer_",
+	"state_",
+	"supervisor_",
+}
+
+var startTime = time.Now()
+
+type statsResponse struct {
+	Version       string         `json:"version"`
+	Uptime        string         `json:"uptime"`
+	UptimeSeconds int64          `json:"uptimeSeconds"`
+	Metrics       map[string]any `json:"metrics"`
+}
+
+// handleStats returns a summary of the current metric values, along with the version and uptime
+func handleStats(w http.ResponseWriter, r *http.Request) {
+	metricFamilies, err := prometheus.DefaultGatherer.Gather()
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	uptime := time.Since(startTime).Truncate(time.Second)
+	resp := statsResponse{
+		Version:       version.GetVersionString(),
+		Uptime:        uptime.String(),
+		UptimeSeconds: int64(uptime.Seconds()),
+		Metrics:       map[string]any{},
+	}
+	for _, metricFamily := range metricFamilies {
+		if !hasStatsMetricPrefix(metricFamily.GetName()) {
+			continue
+		}
+		// Metrics without labels have a single value, otherwise values are keyed by their labels
+		if len(metricFamily.GetMetric()) == 1 &&
+			len(metricFamily.GetMetric()[0].GetLabel()) == 0 {
+			resp.Metrics[metricFamily.GetName()] = metricValue(metricFamily.GetMetric()[0])
+			continue
+		}
+		values := map[string]float64{}
+		for _, metric := range metricFamily.GetMetric() {
+			var labels []string
+			for _, label := range metric.GetLabel() {
+				labels = append(
+					labels,
+					fmt.Sprintf("%s=%s", label.GetName(), label.GetValue()),
+				)
+			}
+			values[strings.Join(labels, ",")] = metricValue(metric)
+		}
+		resp.Metrics[metricFamily.GetName()] = values
+	}
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(resp); err != nil {
+		slog.Error(
+			fmt.Sprintf("failed to write stats response: %s", err),
+		)
+	}
+}
+
+func hasStatsMetricPrefix(name string) bool {
+	for _, prefix := range statsMetricPrefixes {
+		if strings.HasPrefix(name, prefix) {
+			return true
+		}
+	}
+	return false
+}
+
+// metricValue returns the value of a counter or gauge metric
+func metricValue(metric *dto.Metric) float64 {
+	if metric.GetCounter() != nil {
+		return metric.GetCounter().GetValue()
+	}
+	return metric.GetGauge().GetValue()
+}
@@ -0,0 +1,127 @@
+// Copyright 2024 Blink Labs Software
+//
+// Use of this source code is governed by an MIT-style
+// license that can be found in the LICENSE file or at
+// https://opensource.org/licenses/MIT.
+
+package admin
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"time"
+
+	"github.com/blinklabs-io/cdnsd/internal/config"
+	"github.com/blinklabs-io/cdnsd/internal/indexer"
+	"github.com/blinklabs-io/cdnsd/internal/state"
+	"github.com/blinklabs-io/cdnsd/internal/supervisor"
+)
+
+// Start starts the admin listener and returns its HTTP server, so that it can be shut down on exit
+func Start() (*http.Server, error) {
+	cfg := config.GetConfig()
+	listenAddr := fmt.Sprintf(
+		"%s:%d",
+		cfg.Admin.ListenAddress,
+		cfg.Admin.ListenPort,
+	)
+	slog.Info(
+		fmt.Sprintf(
+			"starting admin listener on %s",
+			listenAddr,
+		),
+	)
+	mux := http.NewServeMux()
+	mux.HandleFunc("POST /admin/gc", handleGc)
+	mux.HandleFunc("POST /admin/prune", handlePrune)
+	mux.HandleFunc("GET /admin/dbstats", handleDbStats)
+	mux.HandleFunc("GET /admin/stats", handleStats)
+	mux.HandleFunc("GET /tlds", handleTlds)
+	srv := &http.Server{
+		Addr:         listenAddr,
+		WriteTimeout: 5 * time.Minute,
+		ReadTimeout:  10 * time.Second,
+		Handler:      mux,
+	}
+	// The server isn't restarted after it's shut down
+	supervisor.Run(
+		"admin listener",
+		func() error {
+			if err := srv.ListenAndServe(); !errors.Is(err, http.ErrServerClosed) {
+				return err
+			}
+			return nil
+		},
+	)
+	return srv, nil
+}
+
+func handleGc(w http.ResponseWriter, r *http.Request) {
+	runs, err := state.GetState().RunGC()
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+	writeJson(
+		w,
+		map[string]int{
+			"runs": runs,
+		},
+	)
+}
+
+func handlePrune(w http.ResponseWriter, r *http.Request) {
+	pruned, err := state.GetState().PruneOrphanedRecords()
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+	writeJson(
+		w,
+		map[string]int{
+			"pruned": pruned,
+		},
+	)
+}
+
+func handleDbStats(w http.ResponseWriter, r *http.Request) {
+	stats, err := state.GetState().DbStats()
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+	writeJson(w, stats)
+}
+
+func handleStats(w http.ResponseWriter, r *http.Request) {
+	writeJson(w, state.GetState().Stats())
+}
+
+func handleTlds(w http.ResponseWriter, r *http.Request) {
+	writeJson(w, indexer.GetIndexer().WatchedTlds())
+}
+
+func writeJson(w http.ResponseWriter, v any) {
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(v); err != nil {
+		slog.Error(
+			fmt.Sprintf("failed to write admin response: %s", err),
+		)
+	}
+}
+
+func writeError(w http.ResponseWriter, err error) {
+	slog.Error(
+		fmt.Sprintf("admin request failed: %s", err),
+	)
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusInternalServerError)
+	_ = json.NewEncoder(w).Encode(
+		map[string]string{
+			"error": err.Error(),
+		},
+	)
+}
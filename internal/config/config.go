@@ -8,10 +8,14 @@ package config
 
 import (
 	"fmt"
+	"net"
 	"os"
 	"strings"
+	"time"
 
+	ouroboros "github.com/blinklabs-io/gouroboros"
 	"github.com/kelseyhightower/envconfig"
+	"github.com/miekg/dns"
 	"gopkg.in/yaml.v2"
 )
 
@@ -20,6 +24,7 @@ type Config struct {
 	Metrics  MetricsConfig `yaml:"metrics"`
 	Dns      DnsConfig     `yaml:"dns"`
 	Debug    DebugConfig   `yaml:"debug"`
+	Admin    AdminConfig   `yaml:"admin"`
 	Indexer  IndexerConfig `yaml:"indexer"`
 	State    StateConfig   `yaml:"state"`
 	Tls      TlsConfig     `yaml:"tls"`
@@ -29,19 +34,156 @@ type Config struct {
 type LoggingConfig struct {
 	Debug    bool `yaml:"debug"    envconfig:"LOGGING_DEBUG"`
 	QueryLog bool `yaml:"queryLog" envconfig:"LOGGING_QUERY_LOG"`
+	// Window for collapsing repeated indexer warnings (0 to disable)
+	WarnThrottleWindow time.Duration `yaml:"warnThrottleWindow" envconfig:"LOGGING_WARN_THROTTLE_WINDOW"`
 }
 
 type DnsConfig struct {
-	ListenAddress    string   `yaml:"address"          envconfig:"DNS_LISTEN_ADDRESS"`
-	ListenPort       uint     `yaml:"port"             envconfig:"DNS_LISTEN_PORT"`
-	ListenTlsPort    uint     `yaml:"tlsPort"          envconfig:"DNS_LISTEN_TLS_PORT"`
-	RecursionEnabled bool     `yaml:"recursionEnabled" envconfig:"DNS_RECURSION"`
-	FallbackServers  []string `yaml:"fallbackServers"  envconfig:"DNS_FALLBACK_SERVERS"`
+	ListenAddress       string   `yaml:"address"             envconfig:"DNS_LISTEN_ADDRESS"`
+	ListenPort          uint     `yaml:"port"                envconfig:"DNS_LISTEN_PORT"`
+	ListenTlsPort       uint     `yaml:"tlsPort"             envconfig:"DNS_LISTEN_TLS_PORT"`
+	ListenQuicPort      uint     `yaml:"quicPort"            envconfig:"DNS_LISTEN_QUIC_PORT"`
+	RecursionEnabled    bool     `yaml:"recursionEnabled"    envconfig:"DNS_RECURSION"`
+	FallbackServers     []string `yaml:"fallbackServers"     envconfig:"DNS_FALLBACK_SERVERS"`
+	ChaosVersionEnabled bool     `yaml:"chaosVersionEnabled" envconfig:"DNS_CHAOS_VERSION_ENABLED"`
+	MinimalResponses    bool     `yaml:"minimalResponses"    envconfig:"DNS_MINIMAL_RESPONSES"`
+	GlueCacheSize       int      `yaml:"glueCacheSize"       envconfig:"DNS_GLUE_CACHE_SIZE"`
+	DelegationTtl       uint32   `yaml:"delegationTtl"       envconfig:"DNS_DELEGATION_TTL"`
+	Dns64Prefix         string   `yaml:"dns64Prefix"         envconfig:"DNS_DNS64_PREFIX"`
+	// Listeners to start, which overrides the address/port options above
+	Listeners []ListenerConfig `yaml:"listeners"`
+	// Overall deadline for resolving a query, across all upstream attempts
+	QueryTimeout time.Duration `yaml:"queryTimeout" envconfig:"DNS_QUERY_TIMEOUT"`
+	// DNSSEC key material to serve at the apex of blockchain zones, keyed by zone name
+	DnssecZones map[string]DnssecZoneConfig `yaml:"dnssecZones"`
+	// Maximum number of records served per name (0 for unlimited)
+	MaxRecordsPerName int `yaml:"maxRecordsPerName" envconfig:"DNS_MAX_RECORDS_PER_NAME"`
+	// Action to take when an on-chain update exceeds the per-name record limit
+	MaxRecordsAction string `yaml:"maxRecordsAction" envconfig:"DNS_MAX_RECORDS_ACTION"`
+	// Client networks (CIDR) allowed to use recursion and fallback servers. All clients are allowed when empty
+	AllowRecursionFrom []string `yaml:"allowRecursionFrom" envconfig:"DNS_ALLOW_RECURSION_FROM"`
+	// EDNS0 NSID value returned to clients that request it, with an option to use the hostname instead
+	Nsid         string `yaml:"nsid"         envconfig:"DNS_NSID"`
+	NsidHostname bool   `yaml:"nsidHostname" envconfig:"DNS_NSID_HOSTNAME"`
+	// Only perform recursion and fallback queries when the client sets the RD bit
+	HonorRecursionDesired bool `yaml:"honorRecursionDesired" envconfig:"DNS_HONOR_RECURSION_DESIRED"`
+	// CHAOS class TXT records to serve, keyed by name, which override the built-in names
+	ChaosRecords map[string]string `yaml:"chaosRecords"`
+	// Client networks (CIDR) allowed to query CHAOS class records. All clients are allowed when empty
+	ChaosAllowFrom []string `yaml:"chaosAllowFrom" envconfig:"DNS_CHAOS_ALLOW_FROM"`
+	// Timeouts and connection limit for TCP and TLS listeners
+	TcpReadTimeout    time.Duration `yaml:"tcpReadTimeout"    envconfig:"DNS_TCP_READ_TIMEOUT"`
+	TcpWriteTimeout   time.Duration `yaml:"tcpWriteTimeout"   envconfig:"DNS_TCP_WRITE_TIMEOUT"`
+	TcpIdleTimeout    time.Duration `yaml:"tcpIdleTimeout"    envconfig:"DNS_TCP_IDLE_TIMEOUT"`
+	MaxTcpConnections int           `yaml:"maxTcpConnections" envconfig:"DNS_MAX_TCP_CONNECTIONS"`
+	// Idle timeout for DNS-over-QUIC connections
+	QuicIdleTimeout time.Duration `yaml:"quicIdleTimeout" envconfig:"DNS_QUIC_IDLE_TIMEOUT"`
+	// Number of nameservers to query in parallel for delegated and recursive queries, using the first response
+	ParallelNameserverQueries int `yaml:"parallelNameserverQueries" envconfig:"DNS_PARALLEL_NAMESERVER_QUERIES"`
+	// Policy for resolving blockchain TLDs that also exist in the ICANN root, keyed by TLD
+	CollisionPolicy map[string]string `yaml:"collisionPolicy"`
+	// Response policy rules applied to query names
+	Rpz []RpzRuleConfig `yaml:"rpz"`
+	// Static A/AAAA/TXT records served at the apex of blockchain TLDs when there's no on-chain apex record,
+	// keyed by TLD. Records are specified without an owner name, such as "300 A 192.0.2.1"
+	TldApexRecords map[string][]string `yaml:"tldApexRecords"`
+	// Blockchain TLDs served under another TLD, keyed by alias TLD. A query for x.<alias> is answered
+	// from the records stored for x.<target>
+	TldAliases map[string]string `yaml:"tldAliases"`
+	// Record types removed from answers forwarded from upstream servers
+	StripForwardedTypes []string `yaml:"stripForwardedTypes" envconfig:"DNS_STRIP_FORWARDED_TYPES"`
+	// Recursive resolver used to lookup addresses for nameservers without on-chain glue. A random
+	// fallback server is used when empty
+	GlueResolver string `yaml:"glueResolver" envconfig:"DNS_GLUE_RESOLVER"`
+	// Answer queries for delegations where all nameservers are within the delegated domain and have
+	// on-chain glue by querying those nameservers, rather than returning a referral
+	ResolveOnChainDelegations bool `yaml:"resolveOnChainDelegations" envconfig:"DNS_RESOLVE_ON_CHAIN_DELEGATIONS"`
+	// Accept PROXY protocol (v1 or v2) headers on TCP and TLS listeners to recover the original client
+	// address. Headers are only honored from the listed proxy networks (CIDR)
+	ProxyProtocol            bool     `yaml:"proxyProtocol"            envconfig:"DNS_PROXY_PROTOCOL"`
+	ProxyProtocolTrustedFrom []string `yaml:"proxyProtocolTrustedFrom" envconfig:"DNS_PROXY_PROTOCOL_TRUSTED_FROM"`
+	// Bounds applied to the TTLs of records in forwarded responses. A value of 0 disables that bound
+	MinTtl uint32 `yaml:"minTtl" envconfig:"DNS_MIN_TTL"`
+	MaxTtl uint32 `yaml:"maxTtl" envconfig:"DNS_MAX_TTL"`
+	// Maximum number of resolved ALIAS targets to cache. Set to 0 to disable
+	AliasCacheSize int `yaml:"aliasCacheSize" envconfig:"DNS_ALIAS_CACHE_SIZE"`
+	// Interval between health checks of the fallback servers, which query for the NS records of the
+	// specified name. Servers failing health checks aren't used until they recover. Set to 0 to disable
+	FallbackHealthCheckInterval time.Duration `yaml:"fallbackHealthCheckInterval" envconfig:"DNS_FALLBACK_HEALTH_CHECK_INTERVAL"`
+	FallbackHealthCheckName     string        `yaml:"fallbackHealthCheckName"     envconfig:"DNS_FALLBACK_HEALTH_CHECK_NAME"`
+}
+
+type RpzRuleConfig struct {
+	// Query name to match, which may be a wildcard (*.example.com) to match all names below a domain
+	Name   string `yaml:"name"`
+	Action string `yaml:"action"`
+	// Target name for the CNAME action
+	Target string `yaml:"target"`
+}
+
+const (
+	RpzActionNxdomain = "nxdomain"
+	RpzActionNodata   = "nodata"
+	RpzActionCname    = "cname"
+	RpzActionPassthru = "passthru"
+)
+
+const (
+	// Only answer from blockchain data, never using the fallback servers
+	CollisionPolicyBlockchain = "blockchain"
+	// Only answer from the fallback servers, ignoring blockchain data
+	CollisionPolicyFallback = "fallback"
+	// Answer from blockchain data, using the fallback servers when nothing is found (default)
+	CollisionPolicyPreferBlockchain = "prefer-blockchain"
+)
+
+const (
+	MaxRecordsActionTruncate = "truncate"
+	MaxRecordsActionReject   = "reject"
+)
+
+const (
+	// Refuse to start (default)
+	FingerprintMismatchFail = "fail"
+	// Clear the DB and sync from the configured intercept point
+	FingerprintMismatchWipe = "wipe"
+)
+
+// Non-standard record type for apex CNAME flattening, which is resolved to A/AAAA records at query time
+const RecordTypeAlias = "ALIAS"
+
+type ListenerConfig struct {
+	Address  string `yaml:"address"`
+	Port     uint   `yaml:"port"`
+	Protocol string `yaml:"protocol"`
+}
+
+const (
+	ListenerProtocolUdp  = "udp"
+	ListenerProtocolTcp  = "tcp"
+	ListenerProtocolTls  = "tls"
+	ListenerProtocolQuic = "quic"
+)
+
+type DnssecZoneConfig struct {
+	Ttl uint32 `yaml:"ttl"`
+	// DNSKEY record data in presentation format (flags protocol algorithm key)
+	Dnskey []string `yaml:"dnskey"`
+	// DS record data in presentation format (keytag algorithm digesttype digest)
+	Ds []string `yaml:"ds"`
 }
 
 type DebugConfig struct {
 	ListenAddress string `yaml:"address" envconfig:"DEBUG_ADDRESS"`
 	ListenPort    uint   `yaml:"port"    envconfig:"DEBUG_PORT"`
+	// Serve pprof profiling endpoints on the debug listener. The debug listener is only started when this
+	// is enabled
+	EnablePprof bool `yaml:"enablePprof" envconfig:"DEBUG_ENABLE_PPROF"`
+}
+
+type AdminConfig struct {
+	ListenAddress string `yaml:"address" envconfig:"ADMIN_LISTEN_ADDRESS"`
+	ListenPort    uint   `yaml:"port"    envconfig:"ADMIN_LISTEN_PORT"`
 }
 
 type MetricsConfig struct {
@@ -57,26 +199,66 @@ type IndexerConfig struct {
 	InterceptHash string `yaml:"interceptHash" envconfig:"INDEXER_INTERCEPT_HASH"`
 	InterceptSlot uint64 `yaml:"interceptSlot" envconfig:"INDEXER_INTERCEPT_SLOT"`
 	Verify        bool   `yaml:"verify"        envconfig:"INDEXER_VERIFY"`
+	// Maximum number of transaction outputs decoded concurrently. Outputs are processed sequentially when
+	// this is 0 or 1. The decoded updates are always applied in output order. Decoding is most of the cost
+	// of each output (see BenchmarkHandleEventManyOutputs), so this mostly helps with catch-up sync of
+	// transactions with many outputs on hosts with multiple CPUs
+	OutputWorkers int `yaml:"outputWorkers" envconfig:"INDEXER_OUTPUT_WORKERS"`
+	// Record types accepted from on-chain data. Records with other types are ignored
+	RecordTypes []string `yaml:"recordTypes" envconfig:"INDEXER_RECORD_TYPES"`
+	// Number of blocks that must follow the block containing a domain update before it's served. Updates
+	// from rolled back blocks are discarded. Set to 0 to serve updates immediately
+	MinConfirmations uint64 `yaml:"minConfirmations" envconfig:"INDEXER_MIN_CONFIRMATIONS"`
 }
 
 type StateConfig struct {
-	Directory string `yaml:"dir" envconfig:"STATE_DIR"`
+	Directory     string        `yaml:"dir"           envconfig:"STATE_DIR"`
+	PruneInterval time.Duration `yaml:"pruneInterval" envconfig:"STATE_PRUNE_INTERVAL"`
+	// Action to take on startup when the config fingerprint in the DB doesn't match the current config
+	OnFingerprintMismatch string `yaml:"onFingerprintMismatch" envconfig:"STATE_ON_FINGERPRINT_MISMATCH"`
+	// This is only set from the command line
+	ResetFingerprint bool `yaml:"-" ignored:"true"`
 }
 
 type TlsConfig struct {
 	CertFilePath string `yaml:"certFilePath" envconfig:"TLS_CERT_FILE_PATH"`
 	KeyFilePath  string `yaml:"keyFilePath"  envconfig:"TLS_KEY_FILE_PATH"`
+	// Minimum TLS version (1.0, 1.1, 1.2, 1.3)
+	MinVersion string `yaml:"minVersion" envconfig:"TLS_MIN_VERSION"`
+	// Allowed cipher suites by name, which only apply to TLS 1.2 and older. The Go defaults are used when empty
+	CipherSuites []string `yaml:"cipherSuites" envconfig:"TLS_CIPHER_SUITES"`
+	// ALPN protocols to advertise on the DNS-over-TLS listener
+	Alpn []string `yaml:"alpn" envconfig:"TLS_ALPN"`
+	// CA certificate(s) used to verify client certificates. Client certificates are required when set
+	ClientCaFilePath string `yaml:"clientCaFilePath" envconfig:"TLS_CLIENT_CA_FILE_PATH"`
 }
 
 // Singleton config instance with default values
 var globalConfig = &Config{
 	Logging: LoggingConfig{
-		QueryLog: true,
+		QueryLog:           true,
+		WarnThrottleWindow: 1 * time.Minute,
 	},
 	Dns: DnsConfig{
 		ListenAddress: "",
 		ListenPort:    8053,
 		ListenTlsPort: 8853,
+		QueryTimeout:  5 * time.Second,
+		// These match the defaults from miekg/dns
+		TcpReadTimeout:  2 * time.Second,
+		TcpWriteTimeout: 2 * time.Second,
+		TcpIdleTimeout:  8 * time.Second,
+		QuicIdleTimeout: 30 * time.Second,
+		GlueCacheSize:   1000,
+		AliasCacheSize:  1000,
+		DelegationTtl:   999,
+		// Honor the RD bit from clients
+		HonorRecursionDesired: true,
+		// Truncate records beyond MaxRecordsPerName
+		MaxRecordsAction: MaxRecordsActionTruncate,
+		// Check the fallback servers by querying for the root NS records
+		FallbackHealthCheckInterval: 30 * time.Second,
+		FallbackHealthCheckName:     ".",
 		// hdns.io
 		FallbackServers: []string{
 			"103.196.38.38",
@@ -88,15 +270,49 @@ var globalConfig = &Config{
 		ListenAddress: "localhost",
 		ListenPort:    0,
 	},
+	Admin: AdminConfig{
+		ListenAddress: "localhost",
+		ListenPort:    0,
+	},
+	Tls: TlsConfig{
+		MinVersion: "1.2",
+	},
 	Metrics: MetricsConfig{
 		ListenAddress: "",
 		ListenPort:    8081,
 	},
 	Indexer: IndexerConfig{
 		Verify: true,
+		RecordTypes: []string{
+			"A",
+			"AAAA",
+			RecordTypeAlias,
+			"CAA",
+			"CNAME",
+			"DNAME",
+			"DNSKEY",
+			"DS",
+			"HINFO",
+			"HTTPS",
+			"LOC",
+			"MX",
+			"NAPTR",
+			"NS",
+			"PTR",
+			"RP",
+			"SOA",
+			"SRV",
+			"SSHFP",
+			"SVCB",
+			"TLSA",
+			"TXT",
+			"URI",
+		},
 	},
 	State: StateConfig{
-		Directory: "./.state",
+		Directory:             "./.state",
+		PruneInterval:         1 * time.Hour,
+		OnFingerprintMismatch: FingerprintMismatchFail,
 	},
 	Profiles: []string{
 		// NOTE: this is here because .ada wasn't added to the discovery address when it was originally deployed
@@ -124,6 +340,157 @@ func Load(configFile string) (*Config, error) {
 	if err != nil {
 		return nil, fmt.Errorf("error processing environment: %s", err)
 	}
+	// Check DNS64 prefix
+	if globalConfig.Dns.Dns64Prefix != "" {
+		_, prefixNet, err := net.ParseCIDR(globalConfig.Dns.Dns64Prefix)
+		if err != nil {
+			return nil, fmt.Errorf("invalid DNS64 prefix: %s", err)
+		}
+		if prefixNet.IP.To4() != nil {
+			return nil, fmt.Errorf("invalid DNS64 prefix: must be IPv6")
+		}
+		if prefixLen, _ := prefixNet.Mask.Size(); prefixLen != 96 {
+			return nil, fmt.Errorf("invalid DNS64 prefix: only /96 prefixes are supported")
+		}
+	}
+	// Check recursion ACL
+	for _, cidr := range globalConfig.Dns.AllowRecursionFrom {
+		if _, _, err := net.ParseCIDR(cidr); err != nil {
+			return nil, fmt.Errorf("invalid recursion ACL entry: %s", err)
+		}
+	}
+	// Check CHAOS ACL
+	for _, cidr := range globalConfig.Dns.ChaosAllowFrom {
+		if _, _, err := net.ParseCIDR(cidr); err != nil {
+			return nil, fmt.Errorf("invalid CHAOS ACL entry: %s", err)
+		}
+	}
+	// Check PROXY protocol trusted networks
+	if globalConfig.Dns.ProxyProtocol && len(globalConfig.Dns.ProxyProtocolTrustedFrom) == 0 {
+		return nil, fmt.Errorf("PROXY protocol is enabled but no trusted proxy networks are configured")
+	}
+	for _, cidr := range globalConfig.Dns.ProxyProtocolTrustedFrom {
+		if _, _, err := net.ParseCIDR(cidr); err != nil {
+			return nil, fmt.Errorf("invalid PROXY protocol trusted network: %s", err)
+		}
+	}
+	// Check TLD collision policies
+	for tld, policy := range globalConfig.Dns.CollisionPolicy {
+		switch policy {
+		case CollisionPolicyBlockchain, CollisionPolicyFallback, CollisionPolicyPreferBlockchain:
+		default:
+			return nil, fmt.Errorf(
+				"invalid collision policy for TLD %s: %s",
+				tld,
+				policy,
+			)
+		}
+	}
+	// Check response policy rules
+	for _, rule := range globalConfig.Dns.Rpz {
+		switch rule.Action {
+		case RpzActionNxdomain, RpzActionNodata, RpzActionPassthru:
+		case RpzActionCname:
+			if rule.Target == "" {
+				return nil, fmt.Errorf(
+					"invalid RPZ rule for %s: CNAME action requires a target",
+					rule.Name,
+				)
+			}
+		default:
+			return nil, fmt.Errorf(
+				"invalid RPZ rule for %s: unknown action: %s",
+				rule.Name,
+				rule.Action,
+			)
+		}
+	}
+	// Check forwarded record types to strip
+	for _, recordType := range globalConfig.Dns.StripForwardedTypes {
+		if _, ok := dns.StringToType[strings.ToUpper(recordType)]; !ok {
+			return nil, fmt.Errorf("invalid record type to strip: %s", recordType)
+		}
+	}
+	// Check TLD aliases
+	for alias, target := range globalConfig.Dns.TldAliases {
+		if strings.Contains(strings.Trim(alias, "."), ".") ||
+			strings.Contains(strings.Trim(target, "."), ".") {
+			return nil, fmt.Errorf(
+				"invalid TLD alias %s: %s: only TLDs can be aliased",
+				alias,
+				target,
+			)
+		}
+		// Make sure alias chains don't loop
+		seen := map[string]bool{}
+		for tld := alias; tld != ""; tld = globalConfig.Dns.TldAliases[tld] {
+			if seen[tld] {
+				return nil, fmt.Errorf("TLD alias loop detected for %s", alias)
+			}
+			seen[tld] = true
+		}
+	}
+	// Check state options
+	switch globalConfig.State.OnFingerprintMismatch {
+	case FingerprintMismatchFail, FingerprintMismatchWipe:
+	default:
+		return nil, fmt.Errorf(
+			"invalid fingerprint mismatch action: %s",
+			globalConfig.State.OnFingerprintMismatch,
+		)
+	}
+	// Check indexer options
+	if globalConfig.Indexer.OutputWorkers < 0 {
+		return nil, fmt.Errorf("invalid indexer output workers: must not be negative")
+	}
+	for _, recordType := range globalConfig.Indexer.RecordTypes {
+		if strings.EqualFold(recordType, RecordTypeAlias) {
+			continue
+		}
+		tmpType, ok := dns.StringToType[strings.ToUpper(recordType)]
+		if !ok {
+			return nil, fmt.Errorf("invalid indexer record type: %s", recordType)
+		}
+		// Meta and query types can't be stored as records
+		switch tmpType {
+		case dns.TypeOPT, dns.TypeTSIG, dns.TypeTKEY, dns.TypeAXFR, dns.TypeIXFR, dns.TypeANY, dns.TypeMAILA, dns.TypeMAILB:
+			return nil, fmt.Errorf("unsupported indexer record type: %s", recordType)
+		}
+	}
+	// Check fallback health check options
+	if globalConfig.Dns.FallbackHealthCheckInterval < 0 {
+		return nil, fmt.Errorf("invalid fallback health check interval: must not be negative")
+	}
+	if _, ok := dns.IsDomainName(globalConfig.Dns.FallbackHealthCheckName); !ok {
+		return nil, fmt.Errorf(
+			"invalid fallback health check name: %s",
+			globalConfig.Dns.FallbackHealthCheckName,
+		)
+	}
+	// Check ALIAS cache options
+	if globalConfig.Dns.AliasCacheSize < 0 {
+		return nil, fmt.Errorf("invalid ALIAS cache size: must not be negative")
+	}
+	// Check TTL bounds
+	if globalConfig.Dns.MaxTtl > 0 && globalConfig.Dns.MinTtl > globalConfig.Dns.MaxTtl {
+		return nil, fmt.Errorf(
+			"invalid TTL bounds: min TTL (%d) is greater than max TTL (%d)",
+			globalConfig.Dns.MinTtl,
+			globalConfig.Dns.MaxTtl,
+		)
+	}
+	// Check per-name record limit options
+	if globalConfig.Dns.MaxRecordsPerName < 0 {
+		return nil, fmt.Errorf("invalid max records per name: must not be negative")
+	}
+	switch globalConfig.Dns.MaxRecordsAction {
+	case MaxRecordsActionTruncate, MaxRecordsActionReject:
+	default:
+		return nil, fmt.Errorf(
+			"invalid max records action: %s",
+			globalConfig.Dns.MaxRecordsAction,
+		)
+	}
 	// Check profiles
 	availableProfiles := GetAvailableProfiles()
 	var interceptSlot uint64
@@ -143,6 +510,16 @@ func Load(configFile string) (*Config, error) {
 						}
 					}
 				}
+				// Provide default network magic
+				if profileData.NetworkMagic != 0 {
+					if globalConfig.Indexer.NetworkMagic == 0 {
+						globalConfig.Indexer.NetworkMagic = profileData.NetworkMagic
+					} else {
+						if globalConfig.Indexer.NetworkMagic != profileData.NetworkMagic {
+							return nil, fmt.Errorf("conflicting network magics configured: %d and %d", globalConfig.Indexer.NetworkMagic, profileData.NetworkMagic)
+						}
+					}
+				}
 				// Update intercept slot/hash if earlier than any other profiles so far
 				if interceptSlot == 0 ||
 					profileData.InterceptSlot < interceptSlot {
@@ -161,6 +538,20 @@ func Load(configFile string) (*Config, error) {
 			)
 		}
 	}
+	// Make sure the network magic matches the named network
+	if globalConfig.Indexer.Network != "" &&
+		globalConfig.Indexer.NetworkMagic != 0 {
+		if network, ok := ouroboros.NetworkByName(globalConfig.Indexer.Network); ok {
+			if network.NetworkMagic != globalConfig.Indexer.NetworkMagic {
+				return nil, fmt.Errorf(
+					"network magic %d does not match network %s (%d)",
+					globalConfig.Indexer.NetworkMagic,
+					globalConfig.Indexer.Network,
+					network.NetworkMagic,
+				)
+			}
+		}
+	}
 	// Provide default intercept point from profile(s)
 	if globalConfig.Indexer.InterceptSlot == 0 ||
 		globalConfig.Indexer.InterceptHash == "" {
@@ -7,15 +7,24 @@
 package config
 
 type Profile struct {
-	Network          string // Cardano network name
-	Tld              string // Top-level domain
-	PolicyId         string // Verification asset policy ID
-	ScriptAddress    string // Address to follow
-	InterceptSlot    uint64 // Chain-sync initial intercept slot
-	InterceptHash    string // Chain-sync initial intercept hash
-	DiscoveryAddress string // Auto-discovery address to follow
+	Network           string // Cardano network name
+	NetworkMagic      uint32 // Cardano network magic, required for networks not known by name
+	Tld               string // Top-level domain
+	PolicyId          string // Verification asset policy ID
+	ScriptAddress     string // Address to follow
+	InterceptSlot     uint64 // Chain-sync initial intercept slot
+	InterceptHash     string // Chain-sync initial intercept hash
+	DiscoveryAddress  string // Auto-discovery address to follow
+	AssetNameEncoding string // Encoding of the verification asset name (raw, hex, blake2b, cip68), defaults to raw
 }
 
+const (
+	AssetNameEncodingRaw     = "raw"
+	AssetNameEncodingHex     = "hex"
+	AssetNameEncodingBlake2b = "blake2b"
+	AssetNameEncodingCip68   = "cip68"
+)
+
 func GetProfiles() []Profile {
 	var ret []Profile
 	for k, profile := range Profiles {
@@ -0,0 +1,68 @@
+// Copyright 2024 Blink Labs Software
+//
+// Use of this source code is governed by an MIT-style
+// license that can be found in the LICENSE file or at
+// https://opensource.org/licenses/MIT.
+
+package dns
+
+import (
+	"fmt"
+	"net"
+
+	"github.com/miekg/dns"
+)
+
+// Client networks allowed to use recursion and fallback servers. A nil value allows all clients
+var recursionAllowedNets []*net.IPNet
+
+// Client networks allowed to query CHAOS class records. A nil value allows all clients
+var chaosAllowedNets []*net.IPNet
+
+// parseAcl parses a list of CIDRs into networks. An empty list results in a nil value, which allows all clients
+func parseAcl(cidrs []string) ([]*net.IPNet, error) {
+	if len(cidrs) == 0 {
+		return nil, nil
+	}
+	tmpNets := make([]*net.IPNet, 0, len(cidrs))
+	for _, cidr := range cidrs {
+		_, tmpNet, err := net.ParseCIDR(cidr)
+		if err != nil {
+			return nil, fmt.Errorf("invalid ACL entry %q: %w", cidr, err)
+		}
+		tmpNets = append(tmpNets, tmpNet)
+	}
+	return tmpNets, nil
+}
+
+// aclAllows returns whether the client for a request is allowed by the specified networks
+func aclAllows(allowedNets []*net.IPNet, w dns.ResponseWriter) bool {
+	if allowedNets == nil {
+		return true
+	}
+	var clientIp net.IP
+	switch addr := w.RemoteAddr().(type) {
+	case *net.UDPAddr:
+		clientIp = addr.IP
+	case *net.TCPAddr:
+		clientIp = addr.IP
+	default:
+		return false
+	}
+	for _, tmpNet := range allowedNets {
+		if tmpNet.Contains(clientIp) {
+			return true
+		}
+	}
+	return false
+}
+
+// recursionAllowed returns whether the client for a request may use recursion and fallback servers
+func recursionAllowed(w dns.ResponseWriter) bool {
+	return aclAllows(recursionAllowedNets, w)
+}
+
+// chaosAllowed returns whether the client for a request may query CHAOS class records
+func chaosAllowed(w dns.ResponseWriter) bool {
+	return aclAllows(chaosAllowedNets, w)
+}
@@ -0,0 +1,122 @@
+// Copyright 2024 Blink Labs Software
+//
+// Use of this source code is governed by an MIT-style
+// license that can be found in the LICENSE file or at
+// https://opensource.org/licenses/MIT.
+
+package dns
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"net"
+	"strings"
+
+	"github.com/blinklabs-io/cdnsd/internal/config"
+	"github.com/blinklabs-io/cdnsd/internal/state"
+
+	"github.com/miekg/dns"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+)
+
+var (
+	metricAliasCacheHits = promauto.NewCounter(prometheus.CounterOpts{
+		Name: "dns_alias_cache_hits_total",
+		Help: "total ALIAS target lookups served from the ALIAS cache",
+	})
+	metricAliasCacheMisses = promauto.NewCounter(prometheus.CounterOpts{
+		Name: "dns_alias_cache_misses_total",
+		Help: "total ALIAS target lookups not found in the ALIAS cache",
+	})
+)
+
+var globalAliasCache = newAddressCache(
+	func() int {
+		return config.GetConfig().Dns.AliasCacheSize
+	},
+)
+
+// lookupAlias looks for an ALIAS record at the specified name and, if found, resolves its target and
+// returns the resulting A/AAAA records under the queried name
+func lookupAlias(ctx context.Context, name string, qtype uint16) ([]dns.RR, error) {
+	if qtype != dns.TypeA && qtype != dns.TypeAAAA {
+		return nil, nil
+	}
+	records, err := state.GetState().LookupRecords(
+		[]string{config.RecordTypeAlias},
+		strings.TrimSuffix(dns.CanonicalName(name), "."),
+	)
+	if err != nil {
+		return nil, err
+	}
+	if len(records) == 0 {
+		return nil, nil
+	}
+	// Only the first ALIAS record for a name is used
+	aliasRecord := records[0]
+	target := dns.CanonicalName(aliasRecord.Rhs)
+	if target == dns.CanonicalName(name) {
+		slog.Warn(
+			fmt.Sprintf("ignoring ALIAS record for %s pointing to itself", name),
+		)
+		return nil, nil
+	}
+	addresses, ttl, err := resolveAliasTarget(ctx, target, qtype)
+	if err != nil {
+		return nil, fmt.Errorf("failed to resolve ALIAS target %s: %w", target, err)
+	}
+	// Use the lower of the target and ALIAS record TTLs
+	ttl = minTtl(ttl, aliasRecord.Ttl)
+	var ret []dns.RR
+	for _, address := range addresses {
+		ret = append(ret, addressRecord(dns.Fqdn(name), address, ttl))
+	}
+	return ret, nil
+}
+
+// resolveAliasTarget returns the addresses of the requested type for an ALIAS target along with their
+// TTL, using local storage when possible and the ALIAS cache and upstream servers otherwise
+func resolveAliasTarget(
+	ctx context.Context,
+	target string,
+	qtype uint16,
+) ([]net.IP, uint32, error) {
+	// Check for a target within blockchain data
+	answers, err := lookupLocalRecords(target, qtype)
+	if err != nil {
+		return nil, 0, err
+	}
+	var addresses []net.IP
+	var ttl uint32
+	for _, answer := range answers {
+		switch v := answer.(type) {
+		case *dns.A:
+			addresses = append(addresses, v.A)
+		case *dns.AAAA:
+			addresses = append(addresses, v.AAAA)
+		default:
+			continue
+		}
+		ttl = minTtl(ttl, int(answer.Header().Ttl))
+	}
+	if len(addresses) > 0 {
+		return addresses, ttl, nil
+	}
+	// Check the cache before querying upstream
+	cacheKey := target + "/" + dns.Type(qtype).String()
+	if addresses, ttl := globalAliasCache.get(cacheKey); addresses != nil {
+		metricAliasCacheHits.Inc()
+		return addresses, ttl, nil
+	}
+	metricAliasCacheMisses.Inc()
+	addresses, ttl, err = queryAddresses(ctx, target, qtype)
+	if err != nil {
+		return nil, 0, err
+	}
+	if len(addresses) > 0 {
+		globalAliasCache.set(cacheKey, addresses, ttl)
+	}
+	return addresses, ttl, nil
+}
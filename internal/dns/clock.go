@@ -0,0 +1,32 @@
+// Copyright 2024 Blink Labs Software
+//
+// Use of this source code is governed by an MIT-style
+// license that can be found in the LICENSE file or at
+// https://opensource.org/licenses/MIT.
+
+package dns
+
+import (
+	"time"
+)
+
+// Clock provides the current time for cache expiry
+type Clock interface {
+	Now() time.Time
+}
+
+// Clock used for cache expiry. This can be replaced to pin the current time for testing
+var clock Clock = realClock{}
+
+// SetClock replaces the Clock used for cache expiry. The clock is read by query handlers without any
+// locking, so this must be called before serving starts
+func SetClock(c Clock) {
+	clock = c
+}
+
+// realClock is the default Clock, which uses the system time
+type realClock struct{}
+
+func (realClock) Now() time.Time {
+	return time.Now()
+}
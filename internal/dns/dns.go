@@ -7,19 +7,44 @@
 package dns
 
 import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
 	"fmt"
 	"log/slog"
 	"math/rand"
 	"net"
 	"os"
+	"slices"
+	"strconv"
 	"strings"
+	"time"
 
 	"github.com/blinklabs-io/cdnsd/internal/config"
 	"github.com/blinklabs-io/cdnsd/internal/state"
+	"github.com/blinklabs-io/cdnsd/internal/supervisor"
+	"github.com/blinklabs-io/cdnsd/internal/version"
 
 	"github.com/miekg/dns"
 	"github.com/prometheus/client_golang/prometheus"
 	"github.com/prometheus/client_golang/prometheus/promauto"
+	"golang.org/x/net/netutil"
+)
+
+const (
+	defaultDnssecApexTtl = 3600
+	maxDnameChainLength  = 8
+	maxDomainNameLength  = 255
+	syntheticSoaMinTtl   = 300
+	// EDNS0 UDP payload size advertised to clients (DNS flag day 2020)
+	ednsUdpSize = 1232
+)
+
+// Answer sources for metrics
+const (
+	answerSourceCardano   = "cardano"
+	answerSourceDelegated = "delegated"
+	answerSourceFallback  = "fallback"
 )
 
 var (
@@ -27,136 +52,593 @@ var (
 		Name: "dns_query_total",
 		Help: "total DNS queries handled",
 	})
+	metricAnswersTotal = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "dns_answers_total",
+		Help: "total DNS answers by source",
+	}, []string{"source"})
+	metricInvalidRecordsTotal = promauto.NewCounter(prometheus.CounterOpts{
+		Name: "dns_invalid_records_total",
+		Help: "total stored records skipped because they could not be parsed",
+	})
+	metricAnswersTruncatedTotal = promauto.NewCounter(prometheus.CounterOpts{
+		Name: "dns_answers_truncated_total",
+		Help: "total DNS answers capped by the per-name record limit",
+	})
+	metricForwardedRecordsStrippedTotal = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "dns_forwarded_records_stripped_total",
+		Help: "total records removed from forwarded answers by type",
+	}, []string{"type"})
 )
 
 func Start() error {
 	cfg := config.GetConfig()
-	listenAddr := fmt.Sprintf(
-		"%s:%d",
-		cfg.Dns.ListenAddress,
-		cfg.Dns.ListenPort,
-	)
-	slog.Info(
-		fmt.Sprintf(
-			"starting DNS listener on %s",
-			listenAddr,
-		),
-	)
+	// Setup ACLs
+	var err error
+	recursionAllowedNets, err = parseAcl(cfg.Dns.AllowRecursionFrom)
+	if err != nil {
+		return err
+	}
+	chaosAllowedNets, err = parseAcl(cfg.Dns.ChaosAllowFrom)
+	if err != nil {
+		return err
+	}
+	proxyTrustedNets, err = parseAcl(cfg.Dns.ProxyProtocolTrustedFrom)
+	if err != nil {
+		return err
+	}
+	// Setup TLD apex records
+	if err := loadTldApexRecords(cfg.Dns.TldApexRecords); err != nil {
+		return err
+	}
+	// Setup TLD aliases
+	if err := loadTldAliases(cfg.Dns.TldAliases); err != nil {
+		return err
+	}
+	// Start fallback server health checks
+	startFallbackHealthCheck()
 	// Setup handler
 	dns.HandleFunc(".", handleQuery)
-	// UDP listener
-	serverUdp := &dns.Server{
-		Addr:       listenAddr,
-		Net:        "udp",
-		TsigSecret: nil,
-		ReusePort:  true,
+	listeners := cfg.Dns.Listeners
+	if len(listeners) == 0 {
+		// Build listeners from the single address config
+		listeners = []config.ListenerConfig{
+			{
+				Address:  cfg.Dns.ListenAddress,
+				Port:     cfg.Dns.ListenPort,
+				Protocol: config.ListenerProtocolUdp,
+			},
+			{
+				Address:  cfg.Dns.ListenAddress,
+				Port:     cfg.Dns.ListenPort,
+				Protocol: config.ListenerProtocolTcp,
+			},
+		}
+		if cfg.Tls.CertFilePath != "" && cfg.Tls.KeyFilePath != "" {
+			listeners = append(
+				listeners,
+				config.ListenerConfig{
+					Address:  cfg.Dns.ListenAddress,
+					Port:     cfg.Dns.ListenTlsPort,
+					Protocol: config.ListenerProtocolTls,
+				},
+			)
+			// DNS-over-QUIC is only enabled when a port is specified
+			if cfg.Dns.ListenQuicPort > 0 {
+				listeners = append(
+					listeners,
+					config.ListenerConfig{
+						Address:  cfg.Dns.ListenAddress,
+						Port:     cfg.Dns.ListenQuicPort,
+						Protocol: config.ListenerProtocolQuic,
+					},
+				)
+			}
+		}
 	}
-	go startListener(serverUdp)
-	// TCP listener
-	serverTcp := &dns.Server{
-		Addr:       listenAddr,
-		Net:        "tcp",
-		TsigSecret: nil,
-		ReusePort:  true,
-	}
-	go startListener(serverTcp)
-	// TLS listener
-	if cfg.Tls.CertFilePath != "" && cfg.Tls.KeyFilePath != "" {
-		listenTlsAddr := fmt.Sprintf(
-			"%s:%d",
-			cfg.Dns.ListenAddress,
-			cfg.Dns.ListenTlsPort,
+	for _, listener := range listeners {
+		listenAddr := net.JoinHostPort(
+			listener.Address,
+			strconv.Itoa(int(listener.Port)),
+		)
+		var netType string
+		var tlsConfig *tls.Config
+		switch listener.Protocol {
+		case config.ListenerProtocolUdp:
+			netType = "udp"
+		case config.ListenerProtocolTcp:
+			netType = "tcp"
+		case config.ListenerProtocolTls:
+			tlsConfig, err = loadTlsConfig(listenAddr)
+			if err != nil {
+				return err
+			}
+			netType = "tcp-tls"
+		case config.ListenerProtocolQuic:
+			tlsConfig, err = loadTlsConfig(listenAddr)
+			if err != nil {
+				return err
+			}
+		default:
+			return fmt.Errorf(
+				"unknown DNS listener protocol: %s",
+				listener.Protocol,
+			)
+		}
+		listenerName := fmt.Sprintf(
+			"DNS listener on %s (%s)",
+			listenAddr,
+			listener.Protocol,
 		)
-		serverTls := &dns.Server{
-			Addr:       listenTlsAddr,
-			Net:        "tcp-tls",
-			TsigSecret: nil,
-			ReusePort:  false,
+		slog.Info(
+			fmt.Sprintf("starting %s", listenerName),
+		)
+		// Each listener is restarted independently on failure
+		if listener.Protocol == config.ListenerProtocolQuic {
+			supervisor.Run(
+				listenerName,
+				func() error {
+					return serveQuic(listenAddr, tlsConfig)
+				},
+			)
+			continue
 		}
-		go startListener(serverTls)
+		supervisor.Run(
+			listenerName,
+			func() error {
+				return serveDns(listenAddr, netType, tlsConfig)
+			},
+		)
 	}
 	return nil
 }
 
-func startListener(server *dns.Server) {
-	if err := server.ListenAndServe(); err != nil {
-		slog.Error(
-			fmt.Sprintf("failed to start DNS listener: %s", err),
+// loadTlsConfig returns the TLS config for an encrypted listener on the specified address
+func loadTlsConfig(listenAddr string) (*tls.Config, error) {
+	cfg := config.GetConfig()
+	if cfg.Tls.CertFilePath == "" || cfg.Tls.KeyFilePath == "" {
+		return nil, fmt.Errorf(
+			"TLS listener on %s requires a TLS cert and key",
+			listenAddr,
+		)
+	}
+	cert, err := tls.LoadX509KeyPair(
+		cfg.Tls.CertFilePath,
+		cfg.Tls.KeyFilePath,
+	)
+	if err != nil {
+		return nil, fmt.Errorf("failed to load TLS cert/key: %w", err)
+	}
+	ret := &tls.Config{
+		Certificates: []tls.Certificate{cert},
+		NextProtos:   cfg.Tls.Alpn,
+	}
+	// Minimum TLS version
+	switch cfg.Tls.MinVersion {
+	case "1.0":
+		ret.MinVersion = tls.VersionTLS10
+	case "1.1":
+		ret.MinVersion = tls.VersionTLS11
+	case "", "1.2":
+		ret.MinVersion = tls.VersionTLS12
+	case "1.3":
+		ret.MinVersion = tls.VersionTLS13
+	default:
+		return nil, fmt.Errorf(
+			"unknown TLS min version: %s",
+			cfg.Tls.MinVersion,
 		)
-		os.Exit(1)
 	}
+	// Allowed cipher suites
+	if len(cfg.Tls.CipherSuites) > 0 {
+		cipherSuiteIds := map[string]uint16{}
+		for _, suite := range slices.Concat(tls.CipherSuites(), tls.InsecureCipherSuites()) {
+			cipherSuiteIds[suite.Name] = suite.ID
+		}
+		for _, suiteName := range cfg.Tls.CipherSuites {
+			suiteId, ok := cipherSuiteIds[suiteName]
+			if !ok {
+				return nil, fmt.Errorf("unknown TLS cipher suite: %s", suiteName)
+			}
+			ret.CipherSuites = append(ret.CipherSuites, suiteId)
+		}
+	}
+	// Require and verify client certificates, if configured
+	if cfg.Tls.ClientCaFilePath != "" {
+		caCertPem, err := os.ReadFile(cfg.Tls.ClientCaFilePath)
+		if err != nil {
+			return nil, fmt.Errorf("failed to load TLS client CA: %w", err)
+		}
+		clientCas := x509.NewCertPool()
+		if !clientCas.AppendCertsFromPEM(caCertPem) {
+			return nil, fmt.Errorf(
+				"no certificates found in TLS client CA file: %s",
+				cfg.Tls.ClientCaFilePath,
+			)
+		}
+		ret.ClientCAs = clientCas
+		ret.ClientAuth = tls.RequireAndVerifyClientCert
+	}
+	return ret, nil
+}
+
+// configureTcpServer applies the configured timeouts to a TCP or TLS server
+func configureTcpServer(server *dns.Server) {
+	cfg := config.GetConfig()
+	server.ReadTimeout = cfg.Dns.TcpReadTimeout
+	server.WriteTimeout = cfg.Dns.TcpWriteTimeout
+	if cfg.Dns.TcpIdleTimeout > 0 {
+		idleTimeout := cfg.Dns.TcpIdleTimeout
+		server.IdleTimeout = func() time.Duration {
+			return idleTimeout
+		}
+	}
+}
+
+// serveDns runs a UDP, TCP, or TLS DNS listener on the specified address until it fails
+func serveDns(listenAddr string, netType string, tlsConfig *tls.Config) error {
+	cfg := config.GetConfig()
+	server := &dns.Server{
+		Addr:       listenAddr,
+		Net:        netType,
+		TLSConfig:  tlsConfig,
+		TsigSecret: nil,
+	}
+	switch netType {
+	case "udp":
+		server.ReusePort = true
+		return server.ListenAndServe()
+	case "tcp":
+		server.ReusePort = true
+	}
+	configureTcpServer(server)
+	// Create our own TCP listener to accept PROXY protocol headers and/or enforce a connection limit
+	if cfg.Dns.ProxyProtocol || cfg.Dns.MaxTcpConnections > 0 {
+		listener, err := net.Listen("tcp", listenAddr)
+		if err != nil {
+			return err
+		}
+		if cfg.Dns.ProxyProtocol {
+			listener = newProxyListener(listener)
+		}
+		if cfg.Dns.MaxTcpConnections > 0 {
+			listener = netutil.LimitListener(
+				listener,
+				cfg.Dns.MaxTcpConnections,
+			)
+		}
+		if tlsConfig != nil {
+			listener = tls.NewListener(listener, tlsConfig)
+		}
+		server.Listener = listener
+		return server.ActivateAndServe()
+	}
+	return server.ListenAndServe()
 }
 
 func handleQuery(w dns.ResponseWriter, r *dns.Msg) {
-	if r.Question == nil {
+	// Reject queries without a question
+	if len(r.Question) == 0 {
+		m := new(dns.Msg)
+		m.SetRcodeFormatError(r)
+		if err := w.WriteMsg(m); err != nil {
+			slog.Error(
+				fmt.Sprintf("failed to write response: %s", err),
+			)
+		}
 		return
 	}
 	cfg := config.GetConfig()
+	// Add NSID to responses, if configured and requested
+	w = newNsidResponseWriter(w, r)
+	// Log the query after the response is determined, if enabled
+	if cfg.Logging.QueryLog {
+		queryLogWriter := newQueryLogResponseWriter(w)
+		defer queryLogWriter.logQuery(r)
+		w = queryLogWriter
+	}
 	m := new(dns.Msg)
+	// Determine whether we can recurse or forward for this client
+	recursionAvailable := (cfg.Dns.RecursionEnabled || len(cfg.Dns.FallbackServers) > 0) &&
+		recursionAllowed(w)
+	m.RecursionAvailable = recursionAvailable
+	recurse := recursionAvailable &&
+		(r.RecursionDesired || !cfg.Dns.HonorRecursionDesired)
+	// Overall deadline for resolving this query, including all upstream attempts
+	var ctx context.Context
+	var cancel context.CancelFunc
+	if cfg.Dns.QueryTimeout > 0 {
+		ctx, cancel = context.WithTimeout(
+			context.Background(),
+			cfg.Dns.QueryTimeout,
+		)
+	} else {
+		ctx, cancel = context.WithCancel(context.Background())
+	}
+	defer cancel()
 
-	if cfg.Logging.QueryLog {
-		for _, q := range r.Question {
-			slog.Info(
-				fmt.Sprintf("query: name: %s, type: %s, class: %s",
-					q.Name,
-					dns.Type(q.Qtype).String(),
-					dns.Class(q.Qclass).String(),
-				),
+	// Increment query total metric
+	metricQueryTotal.Inc()
+
+	// Handle CHAOS class queries separately
+	if r.Question[0].Qclass == dns.ClassCHAOS {
+		handleChaosQuery(w, r)
+		return
+	}
+
+	// Apply response policy rules
+	if applyRpz(r, m) {
+		if err := w.WriteMsg(m); err != nil {
+			slog.Error(
+				fmt.Sprintf("failed to write response: %s", err),
 			)
 		}
+		return
 	}
-	// Increment query total metric
-	metricQueryTotal.Inc()
 
-	// Check for known record from local storage
-	lookupRecordTypes := []uint16{r.Question[0].Qtype}
+	// Skip blockchain data entirely for TLDs configured to always use the fallback servers
+	collisionPolicy := collisionPolicyForName(r.Question[0].Name)
+	if collisionPolicy == config.CollisionPolicyFallback {
+		handleFallbackQuery(ctx, w, r, m, recurse)
+		return
+	}
+
+	// Lookup names under an aliased TLD using the target TLD, and present the response under the
+	// queried name. The original query is still used for the fallback servers
+	origW, origR := w, r
+	w, r, err := newTldAliasResponseWriter(w, r)
+	if err != nil {
+		slog.Error(
+			fmt.Sprintf("failed to resolve TLD alias: %s", err),
+		)
+		m.SetRcode(origR, dns.RcodeServerFailure)
+		if err := origW.WriteMsg(m); err != nil {
+			slog.Error(
+				fmt.Sprintf("failed to write response: %s", err),
+			)
+		}
+		return
+	}
+
+	// Check for DNSSEC key material configured for a zone apex
 	switch r.Question[0].Qtype {
-	case dns.TypeA, dns.TypeAAAA:
-		// If the query is for A/AAAA, also try looking up matching CNAME records
-		lookupRecordTypes = append(lookupRecordTypes, dns.TypeCNAME)
+	case dns.TypeDNSKEY, dns.TypeDS:
+		apexRecords, err := dnssecApexRecords(
+			r.Question[0].Name,
+			r.Question[0].Qtype,
+		)
+		if err != nil {
+			slog.Error(
+				fmt.Sprintf("failed to build DNSSEC apex records: %s", err),
+			)
+			m.SetRcode(r, dns.RcodeServerFailure)
+			if err := w.WriteMsg(m); err != nil {
+				slog.Error(
+					fmt.Sprintf("failed to write response: %s", err),
+				)
+			}
+			return
+		}
+		if apexRecords != nil {
+			m.SetReply(r)
+			m.Authoritative = true
+			m.Answer = append(m.Answer, apexRecords...)
+			countAnswer(w, answerSourceCardano)
+			// Send response
+			if err := w.WriteMsg(m); err != nil {
+				slog.Error(
+					fmt.Sprintf("failed to write response: %s", err),
+				)
+			}
+			return
+		}
 	}
-	for _, lookupRecordType := range lookupRecordTypes {
-		records, err := state.GetState().LookupRecords(
-			[]string{dns.Type(lookupRecordType).String()},
-			strings.TrimSuffix(r.Question[0].Name, "."),
+
+	// Check for known record from local storage
+	answers, err := lookupLocalRecords(r.Question[0].Name, r.Question[0].Qtype)
+	if err != nil {
+		slog.Error(
+			fmt.Sprintf("failed to lookup records in state: %s", err),
+		)
+		m.SetRcode(r, dns.RcodeServerFailure)
+		if err := w.WriteMsg(m); err != nil {
+			slog.Error(
+				fmt.Sprintf("failed to write response: %s", err),
+			)
+		}
+		return
+	}
+	// Only return records matching the query class
+	if r.Question[0].Qclass != dns.ClassANY {
+		answers = slices.DeleteFunc(
+			answers,
+			func(rr dns.RR) bool {
+				return rr.Header().Class != r.Question[0].Qclass
+			},
 		)
+		if len(answers) == 0 {
+			answers = nil
+		}
+	}
+	// Resolve an ALIAS record for A/AAAA queries
+	if answers == nil {
+		answers, err = lookupAlias(ctx, r.Question[0].Name, r.Question[0].Qtype)
 		if err != nil {
 			slog.Error(
-				fmt.Sprintf("failed to lookup records in state: %s", err),
+				fmt.Sprintf("failed to lookup ALIAS records: %s", err),
 			)
+			m.SetRcode(r, dns.RcodeServerFailure)
+			if err := w.WriteMsg(m); err != nil {
+				slog.Error(
+					fmt.Sprintf("failed to write response: %s", err),
+				)
+			}
 			return
 		}
-		if records != nil {
+	}
+	// Use static records for the apex of a blockchain TLD, if configured
+	if answers == nil {
+		answers, err = lookupTldApexRecords(
+			r.Question[0].Name,
+			r.Question[0].Qtype,
+		)
+		if err != nil {
+			slog.Error(
+				fmt.Sprintf("failed to lookup TLD apex records: %s", err),
+			)
+			m.SetRcode(r, dns.RcodeServerFailure)
+			if err := w.WriteMsg(m); err != nil {
+				slog.Error(
+					fmt.Sprintf("failed to write response: %s", err),
+				)
+			}
+			return
+		}
+	}
+	// Synthesize AAAA records from A records, if enabled
+	if answers == nil && r.Question[0].Qtype == dns.TypeAAAA {
+		answers, err = synthesizeDns64(r.Question[0].Name)
+		if err != nil {
+			slog.Error(
+				fmt.Sprintf("failed to synthesize DNS64 records: %s", err),
+			)
+			m.SetRcode(r, dns.RcodeServerFailure)
+			if err := w.WriteMsg(m); err != nil {
+				slog.Error(
+					fmt.Sprintf("failed to write response: %s", err),
+				)
+			}
+			return
+		}
+	}
+	if answers != nil {
+		// Cap the number of records returned
+		if cfg.Dns.MaxRecordsPerName > 0 &&
+			len(answers) > cfg.Dns.MaxRecordsPerName {
+			answers = answers[:cfg.Dns.MaxRecordsPerName]
+			metricAnswersTruncatedTotal.Inc()
+		}
+		// Assemble response
+		m.SetReply(r)
+		m.Answer = append(m.Answer, answers...)
+		// Add addresses for SVCB/HTTPS targets
+		if !cfg.Dns.MinimalResponses {
+			extras, err := svcbAdditionalRecords(answers)
+			if err != nil {
+				slog.Error(
+					fmt.Sprintf("failed to lookup SVCB target records: %s", err),
+				)
+			}
+			m.Extra = append(m.Extra, extras...)
+		}
+		countAnswer(w, answerSourceCardano)
+		// Send response
+		if err := w.WriteMsg(m); err != nil {
+			slog.Error(
+				fmt.Sprintf("failed to write response: %s", err),
+			)
+		}
+		// We found our answer, to return from handler
+		return
+	}
+
+	// Generate a SOA record for zones we have on-chain data for, if there's no on-chain SOA record
+	if r.Question[0].Qtype == dns.TypeSOA {
+		soa, err := generateSyntheticSOA(r.Question[0].Name)
+		if err != nil {
+			slog.Error(
+				fmt.Sprintf("failed to generate SOA record: %s", err),
+			)
+			m.SetRcode(r, dns.RcodeServerFailure)
+			if err := w.WriteMsg(m); err != nil {
+				slog.Error(
+					fmt.Sprintf("failed to write response: %s", err),
+				)
+			}
+			return
+		}
+		if soa != nil {
 			// Assemble response
 			m.SetReply(r)
-			for _, tmpRecord := range records {
-				tmpRR, err := stateRecordToDnsRR(tmpRecord)
-				if err != nil {
-					slog.Error(
-						fmt.Sprintf(
-							"failed to convert state record to dns.RR: %s",
-							err,
-						),
-					)
-					return
-				}
-				m.Answer = append(m.Answer, tmpRR)
-			}
+			m.Authoritative = true
+			m.Answer = append(m.Answer, soa)
+			countAnswer(w, answerSourceCardano)
 			// Send response
 			if err := w.WriteMsg(m); err != nil {
 				slog.Error(
 					fmt.Sprintf("failed to write response: %s", err),
 				)
 			}
-			// We found our answer, to return from handler
 			return
 		}
 	}
 
-	// Check for any NS records for parent domains from local storage
-	nameserverDomain, nameservers, err := findNameserversForDomain(
+	// Check for a DNAME record covering the requested name
+	dnameAnswers, dnameRcode, err := lookupDname(
 		r.Question[0].Name,
+		r.Question[0].Qtype,
 	)
+	if err != nil {
+		slog.Error(
+			fmt.Sprintf("failed to lookup DNAME records in state: %s", err),
+		)
+		m.SetRcode(r, dns.RcodeServerFailure)
+		if err := w.WriteMsg(m); err != nil {
+			slog.Error(
+				fmt.Sprintf("failed to write response: %s", err),
+			)
+		}
+		return
+	}
+	if dnameAnswers != nil {
+		// Assemble response
+		m.SetReply(r)
+		m.SetRcode(r, dnameRcode)
+		m.Answer = append(m.Answer, dnameAnswers...)
+		countAnswer(w, answerSourceCardano)
+		// Send response
+		if err := w.WriteMsg(m); err != nil {
+			slog.Error(
+				fmt.Sprintf("failed to write response: %s", err),
+			)
+		}
+		return
+	}
+
+	// Return an authoritative NODATA response if the name exists on-chain without records of the requested type
+	noData, noDataSoa, err := lookupNoData(r.Question[0].Name)
+	if err != nil {
+		slog.Error(
+			fmt.Sprintf("failed to lookup records in state: %s", err),
+		)
+		m.SetRcode(r, dns.RcodeServerFailure)
+		if err := w.WriteMsg(m); err != nil {
+			slog.Error(
+				fmt.Sprintf("failed to write response: %s", err),
+			)
+		}
+		return
+	}
+	if noData {
+		// Assemble response
+		m.SetReply(r)
+		m.Authoritative = true
+		if noDataSoa != nil {
+			m.Ns = append(m.Ns, noDataSoa)
+		}
+		countAnswer(w, answerSourceCardano)
+		// Send response
+		if err := w.WriteMsg(m); err != nil {
+			slog.Error(
+				fmt.Sprintf("failed to write response: %s", err),
+			)
+		}
+		return
+	}
+
+	// Check for any NS records for parent domains from local storage. Nameservers without glue are only
+	// resolved when we will recurse, since self-contained zones and referrals only use glue
+	recurseToNameservers := cfg.Dns.RecursionEnabled && recurse
+	zoneCut, err := findZoneCut(ctx, r.Question[0].Name, recurseToNameservers)
 	if err != nil {
 		slog.Error(
 			fmt.Sprintf(
@@ -166,17 +648,23 @@ func handleQuery(w dns.ResponseWriter, r *dns.Msg) {
 			),
 		)
 	}
-	if nameservers != nil {
+	if zoneCut != nil {
 		// Assemble response
 		m.SetReply(r)
-		if cfg.Dns.RecursionEnabled {
-			// Pick random nameserver for domain
-			tmpNameserver := randomNameserverAddress(nameservers)
-			if tmpNameserver == nil {
+		// Self-contained on-chain zones are resolved directly, if enabled
+		selfContained := cfg.Dns.ResolveOnChainDelegations && zoneCut.selfContained()
+		// Clients not allowed or not requesting recursion get a referral instead
+		if recurseToNameservers || selfContained {
+			// Pick random nameserver(s) for domain
+			tmpNameservers := randomNameserverAddresses(
+				zoneCut.addresses(),
+				nameserverQueryCount(),
+			)
+			if len(tmpNameservers) == 0 {
 				m.SetRcode(r, dns.RcodeServerFailure)
 				if err := w.WriteMsg(m); err != nil {
 					slog.Error(
-						"unable to get nameserver",
+						fmt.Sprintf("failed to write response: %s", err),
 					)
 				}
 				slog.Error(
@@ -184,8 +672,14 @@ func handleQuery(w dns.ResponseWriter, r *dns.Msg) {
 				)
 				return
 			}
-			// Query the random domain nameserver we picked above
-			resp, err := doQuery(r, tmpNameserver.String(), true)
+			// Query the random domain nameserver(s) we picked above. We only follow further
+			// referrals when recursing for the client
+			resp, err := doParallelQuery(
+				ctx,
+				r,
+				tmpNameservers,
+				recurseToNameservers,
+			)
 			if err != nil {
 				// Send failure response
 				m.SetRcode(r, dns.RcodeServerFailure)
@@ -200,6 +694,7 @@ func handleQuery(w dns.ResponseWriter, r *dns.Msg) {
 				return
 			} else {
 				copyResponse(r, resp, m)
+				countAnswer(w, answerSourceDelegated)
 				// Send response
 				if err := w.WriteMsg(m); err != nil {
 					slog.Error(
@@ -209,32 +704,8 @@ func handleQuery(w dns.ResponseWriter, r *dns.Msg) {
 				return
 			}
 		} else {
-			for nameserver, addresses := range nameservers {
-				// NS record
-				ns := &dns.NS{
-					Hdr: dns.RR_Header{Name: (nameserverDomain), Rrtype: dns.TypeNS, Class: dns.ClassINET, Ttl: 999},
-					Ns:  nameserver,
-				}
-				m.Ns = append(m.Ns, ns)
-				for _, address := range addresses {
-					// A or AAAA record
-					if address.To4() != nil {
-						// IPv4
-						a := &dns.A{
-							Hdr: dns.RR_Header{Name: nameserver, Rrtype: dns.TypeA, Class: dns.ClassINET, Ttl: 999},
-							A:   address,
-						}
-						m.Extra = append(m.Extra, a)
-					} else {
-						// IPv6
-						aaaa := &dns.AAAA{
-							Hdr:  dns.RR_Header{Name: nameserver, Rrtype: dns.TypeAAAA, Class: dns.ClassINET, Ttl: 999},
-							AAAA: address,
-						}
-						m.Extra = append(m.Extra, aaaa)
-					}
-				}
-			}
+			zoneCut.addReferral(m, cfg.Dns.MinimalResponses)
+			countAnswer(w, answerSourceDelegated)
 		}
 		// Send response
 		if err := w.WriteMsg(m); err != nil {
@@ -246,12 +717,46 @@ func handleQuery(w dns.ResponseWriter, r *dns.Msg) {
 		return
 	}
 
-	// Query fallback servers, if configured
-	if len(cfg.Dns.FallbackServers) > 0 {
+	// Don't use the fallback servers for TLDs configured to always use blockchain data
+	if collisionPolicy == config.CollisionPolicyBlockchain {
+		m.SetRcode(r, dns.RcodeNameError)
+		if err := w.WriteMsg(m); err != nil {
+			slog.Error(
+				fmt.Sprintf("failed to write response: %s", err),
+			)
+		}
+		return
+	}
+
+	handleFallbackQuery(ctx, origW, origR, m, recurse)
+}
+
+// handleFallbackQuery answers a query using the fallback servers, if configured and allowed, or returns NXDOMAIN
+func handleFallbackQuery(
+	ctx context.Context,
+	w dns.ResponseWriter,
+	r *dns.Msg,
+	m *dns.Msg,
+	recurse bool,
+) {
+	cfg := config.GetConfig()
+	// Refuse non-blockchain names for clients not allowed to use fallback servers
+	if len(cfg.Dns.FallbackServers) > 0 && !recursionAllowed(w) {
+		m.SetRcode(r, dns.RcodeRefused)
+		if err := w.WriteMsg(m); err != nil {
+			slog.Error(
+				fmt.Sprintf("failed to write response: %s", err),
+			)
+		}
+		return
+	}
+
+	// Query fallback servers, if configured and recursion was requested
+	if len(cfg.Dns.FallbackServers) > 0 && recurse {
 		// Pick random fallback server
 		fallbackServer := randomFallbackServer()
 		// Pass along query to chosen fallback server
-		resp, err := doQuery(r, fallbackServer, false)
+		resp, err := doQuery(ctx, r, fallbackServer, false)
 		if err != nil {
 			// Send failure response
 			m.SetRcode(r, dns.RcodeServerFailure)
@@ -266,6 +771,7 @@ func handleQuery(w dns.ResponseWriter, r *dns.Msg) {
 			return
 		} else {
 			copyResponse(r, resp, m)
+			countAnswer(w, answerSourceFallback)
 			// Send response
 			if err := w.WriteMsg(m); err != nil {
 				slog.Error(
@@ -285,19 +791,378 @@ func handleQuery(w dns.ResponseWriter, r *dns.Msg) {
 	}
 }
 
+// collisionPolicyForName returns the configured collision policy for the TLD of the specified name
+func collisionPolicyForName(name string) string {
+	cfg := config.GetConfig()
+	labels := dns.SplitDomainName(name)
+	if len(labels) == 0 {
+		return config.CollisionPolicyPreferBlockchain
+	}
+	tld := labels[len(labels)-1]
+	for tmpTld, policy := range cfg.Dns.CollisionPolicy {
+		if strings.EqualFold(strings.Trim(tmpTld, "."), tld) {
+			return policy
+		}
+	}
+	return config.CollisionPolicyPreferBlockchain
+}
+
+func handleChaosQuery(w dns.ResponseWriter, r *dns.Msg) {
+	cfg := config.GetConfig()
+	m := new(dns.Msg)
+	m.SetReply(r)
+	// Only allow trusted clients to query CHAOS records
+	if !chaosAllowed(w) {
+		m.SetRcode(r, dns.RcodeRefused)
+		if err := w.WriteMsg(m); err != nil {
+			slog.Error(
+				fmt.Sprintf("failed to write response: %s", err),
+			)
+		}
+		return
+	}
+	var txtValue string
+	// Look for a configured record
+	chaosRecordFound := false
+	for chaosName, chaosValue := range cfg.Dns.ChaosRecords {
+		if dns.CanonicalName(chaosName) == dns.CanonicalName(r.Question[0].Name) {
+			txtValue = chaosValue
+			chaosRecordFound = true
+			break
+		}
+	}
+	switch strings.ToLower(r.Question[0].Name) {
+	case "version.bind.", "version.server.":
+		if chaosRecordFound {
+			break
+		}
+		if !cfg.Dns.ChaosVersionEnabled {
+			m.SetRcode(r, dns.RcodeRefused)
+			break
+		}
+		txtValue = version.GetVersionString()
+	case "hostname.bind.", "id.server.":
+		if chaosRecordFound {
+			break
+		}
+		hostname, err := os.Hostname()
+		if err != nil {
+			slog.Error(
+				fmt.Sprintf("failed to get hostname: %s", err),
+			)
+			m.SetRcode(r, dns.RcodeServerFailure)
+			break
+		}
+		txtValue = hostname
+	default:
+		if !chaosRecordFound {
+			m.SetRcode(r, dns.RcodeRefused)
+		}
+	}
+	if txtValue != "" && r.Question[0].Qtype == dns.TypeTXT {
+		txt := &dns.TXT{
+			Hdr: dns.RR_Header{Name: r.Question[0].Name, Rrtype: dns.TypeTXT, Class: dns.ClassCHAOS, Ttl: 0},
+			Txt: []string{txtValue},
+		}
+		m.Answer = append(m.Answer, txt)
+	}
+	// Send response
+	if err := w.WriteMsg(m); err != nil {
+		slog.Error(
+			fmt.Sprintf("failed to write response: %s", err),
+		)
+	}
+}
+
+// dnssecApexRecords returns the configured DNSKEY or DS records for the specified zone apex, if any
+func dnssecApexRecords(name string, recordType uint16) ([]dns.RR, error) {
+	cfg := config.GetConfig()
+	var zoneCfg config.DnssecZoneConfig
+	found := false
+	for zoneName, tmpZoneCfg := range cfg.Dns.DnssecZones {
+		if dns.CanonicalName(zoneName) == dns.CanonicalName(name) {
+			zoneCfg = tmpZoneCfg
+			found = true
+			break
+		}
+	}
+	if !found {
+		return nil, nil
+	}
+	ttl := zoneCfg.Ttl
+	if ttl == 0 {
+		ttl = defaultDnssecApexTtl
+	}
+	rdatas := zoneCfg.Dnskey
+	if recordType == dns.TypeDS {
+		rdatas = zoneCfg.Ds
+	}
+	ret := []dns.RR{}
+	for _, rdata := range rdatas {
+		tmpRR, err := dns.NewRR(
+			fmt.Sprintf(
+				"%s %d IN %s %s",
+				dns.CanonicalName(name),
+				ttl,
+				dns.Type(recordType).String(),
+				rdata,
+			),
+		)
+		if err != nil {
+			return nil, err
+		}
+		ret = append(ret, tmpRR)
+	}
+	if len(ret) == 0 {
+		return nil, nil
+	}
+	return ret, nil
+}
+
+// lookupLocalRecords returns records from local storage for the specified name and type.
+// Queries for other types will also return a matching CNAME record
+func lookupLocalRecords(name string, qtype uint16) ([]dns.RR, error) {
+	// Return records of all types for ANY queries
+	if qtype == dns.TypeANY {
+		recordsByType, err := state.GetState().GetDomainRecordsByType(name)
+		if err != nil {
+			return nil, err
+		}
+		var allRecords []state.DomainRecord
+		for recordType, records := range recordsByType {
+			// ALIAS records are only used to answer A/AAAA queries
+			if strings.EqualFold(recordType, config.RecordTypeAlias) {
+				continue
+			}
+			allRecords = append(allRecords, records...)
+		}
+		return stateRecordsToDnsRRs(allRecords)
+	}
+	lookupRecordTypes := []uint16{qtype}
+	if qtype != dns.TypeCNAME {
+		// A CNAME applies to all other types, so also try looking up matching CNAME records (RFC 1034,
+		// section 3.6.2)
+		lookupRecordTypes = append(lookupRecordTypes, dns.TypeCNAME)
+	}
+	for _, lookupRecordType := range lookupRecordTypes {
+		records, err := state.GetState().LookupRecords(
+			[]string{dns.Type(lookupRecordType).String()},
+			strings.TrimSuffix(name, "."),
+		)
+		if err != nil {
+			return nil, err
+		}
+		if records != nil {
+			return stateRecordsToDnsRRs(records)
+		}
+	}
+	return nil, nil
+}
+
+// lookupDname looks for a DNAME record in local storage covering the specified name and, if found,
+// returns the DNAME and a synthesized CNAME, followed by any records found at the substituted name (RFC 6672)
+func lookupDname(name string, qtype uint16) ([]dns.RR, int, error) {
+	var ret []dns.RR
+	visited := map[string]bool{}
+	currentName := dns.CanonicalName(name)
+	for depth := 0; depth < maxDnameChainLength; depth++ {
+		visited[currentName] = true
+		dname, err := findDnameForName(currentName)
+		if err != nil {
+			return nil, dns.RcodeServerFailure, err
+		}
+		if dname == nil {
+			break
+		}
+		ret = append(ret, dname)
+		// Substitute the DNAME owner with its target
+		targetName := strings.TrimSuffix(
+			currentName,
+			dns.CanonicalName(dname.Hdr.Name),
+		) + dns.CanonicalName(dname.Target)
+		if len(targetName) > maxDomainNameLength {
+			// The substituted name is too long
+			return ret, dns.RcodeYXDomain, nil
+		}
+		ret = append(
+			ret,
+			&dns.CNAME{
+				Hdr: dns.RR_Header{
+					Name:   currentName,
+					Rrtype: dns.TypeCNAME,
+					Class:  dns.ClassINET,
+					Ttl:    dname.Hdr.Ttl,
+				},
+				Target: targetName,
+			},
+		)
+		if visited[targetName] {
+			// Stop following a DNAME loop
+			break
+		}
+		// Continue resolution at the substituted name
+		answers, err := lookupLocalRecords(targetName, qtype)
+		if err != nil {
+			return nil, dns.RcodeServerFailure, err
+		}
+		if answers != nil {
+			ret = append(ret, answers...)
+			break
+		}
+		currentName = targetName
+	}
+	return ret, dns.RcodeSuccess, nil
+}
+
+// findDnameForName returns the DNAME record from local storage at the closest parent of the specified name, if any
+func findDnameForName(name string) (*dns.DNAME, error) {
+	labels := dns.SplitDomainName(name)
+	// DNAME records only apply to names below the owner, so we skip the name itself
+	for startLabelIdx := 1; startLabelIdx < len(labels); startLabelIdx++ {
+		ownerName := strings.Join(labels[startLabelIdx:], ".")
+		records, err := state.GetState().LookupRecords(
+			[]string{"DNAME"},
+			ownerName,
+		)
+		if err != nil {
+			return nil, err
+		}
+		tmpRRs, err := stateRecordsToDnsRRs(records)
+		if err != nil {
+			return nil, err
+		}
+		for _, tmpRR := range tmpRRs {
+			if dname, ok := tmpRR.(*dns.DNAME); ok {
+				return dname, nil
+			}
+		}
+	}
+	return nil, nil
+}
+
+// generateSyntheticSOA returns a SOA record for the specified zone, or nil if we have no on-chain data for it.
+// The serial is bumped each time a domain in the zone is updated
+func generateSyntheticSOA(zoneName string) (*dns.SOA, error) {
+	zoneName = dns.CanonicalName(zoneName)
+	serial, err := state.GetState().GetZoneSerial(zoneName)
+	if err != nil {
+		return nil, err
+	}
+	if serial == 0 {
+		return nil, nil
+	}
+	return &dns.SOA{
+		Hdr: dns.RR_Header{
+			Name:   zoneName,
+			Rrtype: dns.TypeSOA,
+			Class:  dns.ClassINET,
+			Ttl:    syntheticSoaMinTtl,
+		},
+		Ns:      "ns1." + zoneName,
+		Mbox:    "hostmaster." + zoneName,
+		Serial:  serial,
+		Refresh: 3600,
+		Retry:   600,
+		Expire:  604800,
+		Minttl:  syntheticSoaMinTtl,
+	}, nil
+}
+
+// lookupNoData returns whether the specified name exists in local storage with records of other types, along with
+// the SOA record for the enclosing zone to use in the authority section. Names at or below a delegation point
+// are not considered
+func lookupNoData(name string) (bool, *dns.SOA, error) {
+	name = dns.CanonicalName(name)
+	recordsByType, err := state.GetState().GetDomainRecordsByType(name)
+	if err != nil {
+		return false, nil, err
+	}
+	if len(recordsByType) == 0 {
+		return false, nil, nil
+	}
+	// Find the SOA for the closest enclosing zone, checking for delegations along the way
+	labels := dns.SplitDomainName(name)
+	for startLabelIdx := 0; startLabelIdx < len(labels); startLabelIdx++ {
+		lookupName := strings.Join(labels[startLabelIdx:], ".")
+		nsRecords, err := state.GetState().
+			LookupRecords([]string{"NS"}, lookupName)
+		if err != nil {
+			return false, nil, err
+		}
+		if len(nsRecords) > 0 {
+			return false, nil, nil
+		}
+		soa, err := generateSyntheticSOA(lookupName)
+		if err != nil {
+			return false, nil, err
+		}
+		if soa != nil {
+			return true, soa, nil
+		}
+	}
+	return true, nil, nil
+}
+
+// stateRecordsToDnsRRs converts state records to dns.RR, skipping any records that can't be parsed. An error is
+// returned if none of the records could be parsed
+func stateRecordsToDnsRRs(records []state.DomainRecord) ([]dns.RR, error) {
+	if len(records) == 0 {
+		return nil, nil
+	}
+	ret := make([]dns.RR, 0, len(records))
+	for _, tmpRecord := range records {
+		tmpRR, err := stateRecordToDnsRR(tmpRecord)
+		if err != nil {
+			slog.Warn(
+				fmt.Sprintf(
+					"skipping invalid record: %s: %s: %s: %s",
+					tmpRecord.Type,
+					tmpRecord.Lhs,
+					tmpRecord.Rhs,
+					err,
+				),
+			)
+			metricInvalidRecordsTotal.Inc()
+			continue
+		}
+		ret = append(ret, tmpRR)
+	}
+	if len(ret) == 0 {
+		return nil, fmt.Errorf(
+			"failed to convert any of %d state records to dns.RR",
+			len(records),
+		)
+	}
+	return ret, nil
+}
+
 func stateRecordToDnsRR(record state.DomainRecord) (dns.RR, error) {
 	tmpTtl := ""
 	if record.Ttl > 0 {
 		tmpTtl = fmt.Sprintf("%d", record.Ttl)
 	}
+	// Use the class from on-chain metadata, if provided
+	tmpClass := "IN"
+	if record.Class != "" {
+		tmpClass = record.Class
+	}
 	tmpRR := fmt.Sprintf(
-		"%s %s IN %s %s",
+		"%s %s %s %s %s",
 		record.Lhs,
 		tmpTtl,
+		tmpClass,
 		record.Type,
 		record.Rhs,
 	)
-	return dns.NewRR(tmpRR)
+	ret, err := dns.NewRR(tmpRR)
+	if err != nil {
+		return nil, err
+	}
+	// NewRR returns nil without an error for empty input
+	if ret == nil {
+		return nil, fmt.Errorf("empty record")
+	}
+	return ret, nil
 }
 
 func copyResponse(req *dns.Msg, srcResp *dns.Msg, destResp *dns.Msg) {
@@ -307,32 +1172,101 @@ func copyResponse(req *dns.Msg, srcResp *dns.Msg, destResp *dns.Msg) {
 	// Copy relevant data from original request and source response into destination response
 	destResp.SetRcode(req, srcResp.MsgHdr.Rcode)
 	destResp.RecursionDesired = req.RecursionDesired
-	destResp.RecursionAvailable = srcResp.RecursionAvailable
-	if srcResp.Ns != nil {
-		destResp.Ns = append(destResp.Ns, srcResp.Ns...)
+	// Omit authority and additional sections for positive answers in minimal responses mode
+	minimal := config.GetConfig().Dns.MinimalResponses &&
+		srcResp.Rcode == dns.RcodeSuccess &&
+		len(srcResp.Answer) > 0
+	if srcResp.Ns != nil && !minimal {
+		destResp.Ns = append(
+			destResp.Ns,
+			clampTtls(stripForwardedRecords(srcResp.Ns))...,
+		)
 	}
 	if srcResp.Answer != nil {
-		destResp.Answer = append(destResp.Answer, srcResp.Answer...)
+		destResp.Answer = append(
+			destResp.Answer,
+			clampTtls(stripForwardedRecords(srcResp.Answer))...,
+		)
+	}
+	if srcResp.Extra != nil && !minimal {
+		for _, extra := range clampTtls(stripForwardedRecords(srcResp.Extra)) {
+			// Strip the upstream OPT pseudo-record, since its parameters apply to our upstream connection
+			if extra.Header().Rrtype == dns.TypeOPT {
+				continue
+			}
+			destResp.Extra = append(destResp.Extra, extra)
+		}
+	}
+	// Attach our own OPT pseudo-record for EDNS clients
+	if reqOpt := req.IsEdns0(); reqOpt != nil {
+		destResp.SetEdns0(ednsUdpSize, reqOpt.Do())
+	}
+}
+
+// clampTtls limits the TTLs of the records to the configured bounds, so that upstream servers can't
+// dictate extreme TTLs to our clients
+func clampTtls(records []dns.RR) []dns.RR {
+	cfg := config.GetConfig()
+	for _, record := range records {
+		hdr := record.Header()
+		// The OPT pseudo-record uses the TTL field for extended flags
+		if hdr.Rrtype == dns.TypeOPT {
+			continue
+		}
+		hdr.Ttl = clampTtl(hdr.Ttl, cfg.Dns.MinTtl, cfg.Dns.MaxTtl)
+	}
+	return records
+}
+
+// clampTtl limits the TTL to the specified bounds. A bound of 0 is ignored
+func clampTtl(ttl uint32, minTtl uint32, maxTtl uint32) uint32 {
+	if ttl < minTtl {
+		ttl = minTtl
 	}
-	if srcResp.Extra != nil {
-		destResp.Extra = append(destResp.Extra, srcResp.Extra...)
+	if maxTtl > 0 && ttl > maxTtl {
+		ttl = maxTtl
 	}
+	return ttl
 }
 
-func randomNameserverAddress(nameservers map[string][]net.IP) net.IP {
-	// Put all namserver addresses in single list
-	tmpNameservers := []net.IP{}
-	for _, addresses := range nameservers {
-		tmpNameservers = append(tmpNameservers, addresses...)
+// stripForwardedRecords returns the records with any configured types to strip from forwarded answers
+// removed, along with any signatures covering them
+func stripForwardedRecords(records []dns.RR) []dns.RR {
+	stripTypes := config.GetConfig().Dns.StripForwardedTypes
+	if len(stripTypes) == 0 {
+		return records
 	}
-	if len(tmpNameservers) > 0 {
-		tmpNameserver := tmpNameservers[rand.Intn(len(tmpNameservers))]
-		return tmpNameserver
+	ret := make([]dns.RR, 0, len(records))
+	for _, record := range records {
+		recordType := record.Header().Rrtype
+		if rrsig, ok := record.(*dns.RRSIG); ok {
+			recordType = rrsig.TypeCovered
+		}
+		recordTypeName := dns.Type(recordType).String()
+		if slices.ContainsFunc(
+			stripTypes,
+			func(stripType string) bool {
+				return strings.EqualFold(stripType, recordTypeName)
+			},
+		) {
+			metricForwardedRecordsStrippedTotal.WithLabelValues(recordTypeName).Inc()
+			continue
+		}
+		ret = append(ret, record)
 	}
-	return nil
+	return ret
 }
 
-func doQuery(msg *dns.Msg, address string, recursive bool) (*dns.Msg, error) {
+func doQuery(
+	ctx context.Context,
+	msg *dns.Msg,
+	address string,
+	recursive bool,
+) (*dns.Msg, error) {
+	// Stop if the overall query deadline has been reached
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
 	// Default to a random fallback server if no address is specified
 	if address == "" {
 		address = randomFallbackServer()
@@ -348,7 +1282,7 @@ func doQuery(msg *dns.Msg, address string, recursive bool) (*dns.Msg, error) {
 			formatMessageQuestionSection(msg.Question),
 		),
 	)
-	resp, err := dns.Exchange(msg, address)
+	resp, err := upstreamExchanger.Exchange(ctx, msg, address)
 	if err != nil {
 		return nil, err
 	}
@@ -372,22 +1306,31 @@ func doQuery(msg *dns.Msg, address string, recursive bool) (*dns.Msg, error) {
 	if recursive {
 		if len(resp.Ns) > 0 {
 			nameservers := getNameserversFromResponse(resp)
+			// Return the current response for a negative answer (SOA only), since there's no referral to follow
+			if len(nameservers) == 0 {
+				return resp, nil
+			}
+			// Query multiple nameservers with glue in parallel, if configured
+			if queryCount := nameserverQueryCount(); queryCount > 1 {
+				addresses := randomNameserverAddresses(nameservers, queryCount)
+				if len(addresses) > 0 {
+					return doParallelQuery(ctx, msg, addresses, true)
+				}
+			}
 			randNsName, randNsAddress := randomNameserver(nameservers)
 			if randNsAddress == "" {
-				m := createQuery(randNsName, dns.TypeA)
-				// XXX: should this query the fallback servers or the server that gave us the NS response?
-				resp, err := doQuery(m, "", false)
+				addresses, err := resolveNameserverAddress(ctx, randNsName)
 				if err != nil {
 					return nil, err
 				}
-				randNsAddress = getAddressForNameFromResponse(resp, randNsName)
-				if randNsAddress == "" {
+				if len(addresses) == 0 {
 					// Return the current response if we couldn't get an address for the nameserver
 					return resp, nil
 				}
+				randNsAddress = addresses[rand.Intn(len(addresses))].String()
 			}
 			// Perform recursive query
-			return doQuery(msg, randNsAddress, true)
+			return doQuery(ctx, msg, randNsAddress, true)
 		} else {
 			// Return the current response if there is no authority information
 			return resp, nil
@@ -396,58 +1339,20 @@ func doQuery(msg *dns.Msg, address string, recursive bool) (*dns.Msg, error) {
 	return resp, nil
 }
 
-func findNameserversForDomain(
-	recordName string,
-) (string, map[string][]net.IP, error) {
-	// Split record name into labels and lookup each domain and parent until we get a hit
-	queryLabels := dns.SplitDomainName(recordName)
-
-	// Special case for root domain
-	if queryLabels == nil {
-		queryLabels = append(queryLabels, "")
-	}
-
-	// Check on-chain domains first
-	for startLabelIdx := 0; startLabelIdx < len(queryLabels); startLabelIdx++ {
-		lookupDomainName := strings.Join(queryLabels[startLabelIdx:], ".")
-		// Convert to canonical form for consistency
-		lookupDomainName = dns.CanonicalName(lookupDomainName)
-		nsRecords, err := state.GetState().
-			LookupRecords([]string{"NS"}, lookupDomainName)
-		if err != nil {
-			return "", nil, err
-		}
-		if len(nsRecords) > 0 {
-			ret := map[string][]net.IP{}
-			for _, nsRecord := range nsRecords {
-				// Get matching A/AAAA records for NS entry
-				aRecords, err := state.GetState().
-					LookupRecords([]string{"A", "AAAA"}, nsRecord.Rhs)
-				if err != nil {
-					return "", nil, err
-				}
-				for _, aRecord := range aRecords {
-					ret[nsRecord.Rhs] = append(
-						ret[nsRecord.Rhs],
-						net.ParseIP(aRecord.Rhs),
-					)
-				}
-			}
-			return dns.Fqdn(lookupDomainName), ret, nil
-		}
-	}
-
-	return "", nil, nil
-}
-
 func getNameserversFromResponse(msg *dns.Msg) map[string][]net.IP {
 	if len(msg.Ns) == 0 {
 		return nil
 	}
 	ret := map[string][]net.IP{}
 	for _, ns := range msg.Ns {
-		// TODO: handle SOA
 		switch v := ns.(type) {
+		case *dns.SOA:
+			// A SOA in the authority section indicates a negative (NODATA/NXDOMAIN) response rather
+			// than a referral, so there are no nameservers to return
+			return nil
+		case *dns.DS:
+			// DS records accompany referrals to signed zones and don't contain nameservers
+			continue
 		case *dns.NS:
 			nsName := v.Ns
 			ret[nsName] = []net.IP{}
@@ -473,38 +1378,6 @@ func getNameserversFromResponse(msg *dns.Msg) map[string][]net.IP {
 	return ret
 }
 
-func getAddressForNameFromResponse(msg *dns.Msg, recordName string) string {
-	var retRR dns.RR
-	for _, answer := range msg.Answer {
-		if answer.Header().Name == recordName {
-			retRR = answer
-			break
-		}
-	}
-	if retRR == nil {
-		for _, extra := range msg.Extra {
-			if extra.Header().Name == recordName {
-				retRR = extra
-				break
-			}
-		}
-	}
-	if retRR == nil {
-		return ""
-	}
-	switch v := retRR.(type) {
-	case *dns.A:
-		if v.A != nil {
-			return v.A.String()
-		}
-	case *dns.AAAA:
-		if v.AAAA != nil {
-			return v.AAAA.String()
-		}
-	}
-	return ""
-}
-
 func randomNameserver(nameservers map[string][]net.IP) (string, string) {
 	mapKeys := []string{}
 	for k := range nameservers {
@@ -513,6 +1386,9 @@ func randomNameserver(nameservers map[string][]net.IP) (string, string) {
 	if len(mapKeys) > 0 {
 		randNsName := mapKeys[rand.Intn(len(mapKeys))]
 		randNsAddresses := nameservers[randNsName]
+		if len(randNsAddresses) == 0 {
+			return randNsName, ""
+		}
 		randNsAddress := randNsAddresses[rand.Intn(len(randNsAddresses))].String()
 		return randNsName, randNsAddress
 	}
@@ -528,9 +1404,9 @@ func createQuery(recordName string, recordType uint16) *dns.Msg {
 
 func randomFallbackServer() string {
 	cfg := config.GetConfig()
-	return cfg.Dns.FallbackServers[rand.Intn(
-		len(cfg.Dns.FallbackServers),
-	)]
+	// Skip servers failing health checks
+	servers := globalFallbackHealth.healthyServers(cfg.Dns.FallbackServers)
+	return servers[rand.Intn(len(servers))]
 }
 
 func formatMessageAnswerSection(section []dns.RR) string {
@@ -0,0 +1,127 @@
+// Copyright 2024 Blink Labs Software
+//
+// Use of this source code is governed by an MIT-style
+// license that can be found in the LICENSE file or at
+// https://opensource.org/licenses/MIT.
+
+package dns
+
+import (
+	"fmt"
+	"net"
+
+	"github.com/blinklabs-io/cdnsd/internal/config"
+
+	"github.com/miekg/dns"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+)
+
+var (
+	metricDns64SynthesizedTotal = promauto.NewCounter(prometheus.CounterOpts{
+		Name: "dns_dns64_synthesized_total",
+		Help: "total AAAA answers synthesized from A records via DNS64",
+	})
+)
+
+// IPv4 ranges that are never synthesized (RFC 6147 section 5.1.4)
+var dns64ExcludedNets = mustParseCIDRs(
+	"0.0.0.0/8",
+	"127.0.0.0/8",
+	"169.254.0.0/16",
+	"255.255.255.255/32",
+)
+
+// IPv4 ranges that must not be used with the well-known prefix (RFC 6052 section 3.1)
+var dns64WellKnownExcludedNets = mustParseCIDRs(
+	"10.0.0.0/8",
+	"100.64.0.0/10",
+	"172.16.0.0/12",
+	"192.168.0.0/16",
+)
+
+var dns64WellKnownPrefix = net.ParseIP("64:ff9b::")
+
+// synthesizeDns64 returns AAAA records synthesized from the on-chain A records for the specified name
+// using the configured NAT64 prefix, or nil if DNS64 is disabled or there are no usable A records
+func synthesizeDns64(name string) ([]dns.RR, error) {
+	cfg := config.GetConfig()
+	if cfg.Dns.Dns64Prefix == "" {
+		return nil, nil
+	}
+	_, prefixNet, err := net.ParseCIDR(cfg.Dns.Dns64Prefix)
+	if err != nil {
+		return nil, fmt.Errorf("invalid DNS64 prefix: %w", err)
+	}
+	aRecords, err := lookupLocalRecords(name, dns.TypeA)
+	if err != nil {
+		return nil, err
+	}
+	var ret []dns.RR
+	for _, aRecord := range aRecords {
+		a, ok := aRecord.(*dns.A)
+		if !ok {
+			// Keep any CNAME records as-is
+			ret = append(ret, aRecord)
+			continue
+		}
+		aaaa := dns64Address(prefixNet, a.A.To4())
+		if aaaa == nil {
+			continue
+		}
+		ret = append(
+			ret,
+			&dns.AAAA{
+				Hdr: dns.RR_Header{
+					Name:   a.Hdr.Name,
+					Rrtype: dns.TypeAAAA,
+					Class:  dns.ClassINET,
+					Ttl:    a.Hdr.Ttl,
+				},
+				AAAA: aaaa,
+			},
+		)
+	}
+	for _, tmpRR := range ret {
+		if tmpRR.Header().Rrtype == dns.TypeAAAA {
+			metricDns64SynthesizedTotal.Inc()
+			return ret, nil
+		}
+	}
+	return nil, nil
+}
+
+// dns64Address embeds an IPv4 address in a /96 NAT64 prefix, returning nil for excluded addresses
+func dns64Address(prefixNet *net.IPNet, ipv4 net.IP) net.IP {
+	if ipv4 == nil {
+		return nil
+	}
+	for _, excludedNet := range dns64ExcludedNets {
+		if excludedNet.Contains(ipv4) {
+			return nil
+		}
+	}
+	if prefixNet.IP.Equal(dns64WellKnownPrefix) {
+		for _, excludedNet := range dns64WellKnownExcludedNets {
+			if excludedNet.Contains(ipv4) {
+				return nil
+			}
+		}
+	}
+	ret := make(net.IP, net.IPv6len)
+	copy(ret, prefixNet.IP.To16())
+	copy(ret[12:], ipv4)
+	return ret
+}
+
+func mustParseCIDRs(cidrs ...string) []*net.IPNet {
+	ret := make([]*net.IPNet, 0, len(cidrs))
+	for _, cidr := range cidrs {
+		_, tmpNet, err := net.ParseCIDR(cidr)
+		if err != nil {
+			panic(err)
+		}
+		ret = append(ret, tmpNet)
+	}
+	return ret
+}
@@ -0,0 +1,376 @@
+// Copyright 2024 Blink Labs Software
+//
+// Use of this source code is governed by an MIT-style
+// license that can be found in the LICENSE file or at
+// https://opensource.org/licenses/MIT.
+
+package dns
+
+import (
+	"fmt"
+	"io"
+	"log/slog"
+	"net"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/blinklabs-io/cdnsd/internal/config"
+	"github.com/blinklabs-io/cdnsd/internal/state"
+
+	"github.com/miekg/dns"
+)
+
+// testResponseWriter captures the response written by the query handler
+type testResponseWriter struct {
+	msg *dns.Msg
+}
+
+func (w *testResponseWriter) LocalAddr() net.Addr {
+	return &net.UDPAddr{IP: net.IPv4(127, 0, 0, 1), Port: 53}
+}
+
+func (w *testResponseWriter) RemoteAddr() net.Addr {
+	return &net.UDPAddr{IP: net.IPv4(127, 0, 0, 1), Port: 12345}
+}
+
+func (w *testResponseWriter) WriteMsg(m *dns.Msg) error {
+	w.msg = m
+	return nil
+}
+
+func (w *testResponseWriter) Write(b []byte) (int, error) {
+	w.msg = new(dns.Msg)
+	if err := w.msg.Unpack(b); err != nil {
+		return 0, err
+	}
+	return len(b), nil
+}
+
+func (w *testResponseWriter) Close() error {
+	return nil
+}
+
+func (w *testResponseWriter) TsigStatus() error {
+	return nil
+}
+
+func (w *testResponseWriter) TsigTimersOnly(bool) {}
+
+func (w *testResponseWriter) Hijack() {}
+
+// setupTestState loads an in-memory state containing the specified domains
+func setupTestState(t testing.TB, domains map[string][]state.DomainRecord) {
+	t.Helper()
+	if err := state.GetState().LoadInMemory(); err != nil {
+		t.Fatalf("failed to load state: %s", err)
+	}
+	t.Cleanup(func() {
+		_ = state.GetState().Close()
+	})
+	for domainName, records := range domains {
+		if err := state.GetState().UpdateDomain(domainName, records); err != nil {
+			t.Fatalf("failed to update domain %s: %s", domainName, err)
+		}
+	}
+}
+
+// testQuery sends a query for the specified name and type to the query handler and returns the response
+func testQuery(t testing.TB, name string, qtype uint16) *dns.Msg {
+	t.Helper()
+	req := new(dns.Msg)
+	req.SetQuestion(name, qtype)
+	w := &testResponseWriter{}
+	handleQuery(w, req)
+	if w.msg == nil {
+		t.Fatalf("no response for %s %s", name, dns.TypeToString[qtype])
+	}
+	return w.msg
+}
+
+// testClock is a Clock pinned to a settable time
+type testClock struct {
+	now time.Time
+}
+
+func (c *testClock) Now() time.Time {
+	return c.now
+}
+
+// setTestClock pins the clock used for cache expiry until the end of the test
+func setTestClock(t testing.TB) *testClock {
+	t.Helper()
+	c := &testClock{
+		now: time.Date(2024, time.January, 1, 0, 0, 0, 0, time.UTC),
+	}
+	SetClock(c)
+	t.Cleanup(func() {
+		SetClock(realClock{})
+	})
+	return c
+}
+
+func TestMain(m *testing.M) {
+	// Don't use any upstream servers or log queries
+	cfg := config.GetConfig()
+	cfg.Dns.FallbackServers = nil
+	cfg.Dns.RecursionEnabled = false
+	cfg.Logging.QueryLog = false
+	slog.SetDefault(slog.New(slog.NewTextHandler(io.Discard, nil)))
+	os.Exit(m.Run())
+}
+
+func TestReferralWithoutGlue(t *testing.T) {
+	setupTestState(
+		t,
+		map[string][]state.DomainRecord{
+			"example.test.": {
+				{Lhs: "example.test.", Type: "NS", Ttl: 300, Rhs: "ns1.example.test."},
+				{Lhs: "example.test.", Type: "NS", Ttl: 300, Rhs: "ns1.example.net."},
+				{Lhs: "ns1.example.test.", Type: "A", Ttl: 300, Rhs: "192.0.2.53"},
+			},
+		},
+	)
+	cfg := config.GetConfig()
+	minimalResponses := cfg.Dns.MinimalResponses
+	cfg.Dns.MinimalResponses = false
+	t.Cleanup(func() {
+		cfg.Dns.MinimalResponses = minimalResponses
+	})
+	setTestClock(t)
+	// An address for the nameserver without glue would be added to the referral if it was resolved
+	globalGlueCache.set("ns1.example.net.", []net.IP{net.ParseIP("198.51.100.53")}, 300)
+	resp := testQuery(t, "www.example.test.", dns.TypeA)
+	if len(resp.Ns) != 2 {
+		t.Fatalf("expected a referral with 2 NS records, got: %v", resp.Ns)
+	}
+	for _, record := range resp.Extra {
+		if record.Header().Name == "ns1.example.net." {
+			t.Fatalf("unexpected address for nameserver without glue: %s", record)
+		}
+	}
+}
+
+func TestCnameForOtherTypes(t *testing.T) {
+	setupTestState(
+		t,
+		map[string][]state.DomainRecord{
+			"example.test.": {
+				{Lhs: "www.example.test.", Type: "CNAME", Ttl: 300, Rhs: "example.test."},
+				{Lhs: "example.test.", Type: "MX", Ttl: 300, Rhs: "10 mail.example.test."},
+			},
+		},
+	)
+	testDefs := []struct {
+		qtype    uint16
+		expected uint16
+	}{
+		// The CNAME is returned for types other than A/AAAA rather than NODATA
+		{qtype: dns.TypeMX, expected: dns.TypeCNAME},
+		{qtype: dns.TypeTXT, expected: dns.TypeCNAME},
+		{qtype: dns.TypeA, expected: dns.TypeCNAME},
+		{qtype: dns.TypeCNAME, expected: dns.TypeCNAME},
+	}
+	for _, testDef := range testDefs {
+		resp := testQuery(t, "www.example.test.", testDef.qtype)
+		if resp.Rcode != dns.RcodeSuccess {
+			t.Fatalf(
+				"%s: unexpected response code: %s",
+				dns.TypeToString[testDef.qtype],
+				dns.RcodeToString[resp.Rcode],
+			)
+		}
+		if len(resp.Answer) != 1 || resp.Answer[0].Header().Rrtype != testDef.expected {
+			t.Fatalf(
+				"%s: expected a single %s answer, got: %v",
+				dns.TypeToString[testDef.qtype],
+				dns.TypeToString[testDef.expected],
+				resp.Answer,
+			)
+		}
+	}
+	// A name with other records still gets NODATA
+	resp := testQuery(t, "example.test.", dns.TypeTXT)
+	if resp.Rcode != dns.RcodeSuccess || len(resp.Answer) != 0 || !resp.Authoritative {
+		t.Fatalf("expected authoritative NODATA, got: %s", resp)
+	}
+}
+
+func TestTldAlias(t *testing.T) {
+	setupTestState(
+		t,
+		map[string][]state.DomainRecord{
+			"example.ada.": {
+				{Lhs: "example.ada.", Type: "A", Ttl: 300, Rhs: "192.0.2.1"},
+				{Lhs: "sub.example.ada.", Type: "NS", Ttl: 300, Rhs: "ns1.sub.example.ada."},
+				{Lhs: "ns1.sub.example.ada.", Type: "A", Ttl: 300, Rhs: "192.0.2.53"},
+			},
+		},
+	)
+	if err := loadTldAliases(map[string]string{"alias": "ada"}); err != nil {
+		t.Fatalf("failed to load TLD aliases: %s", err)
+	}
+	t.Cleanup(func() {
+		tldAliases = nil
+	})
+	testDefs := []struct {
+		name       string
+		qtype      uint16
+		answerName string
+		nsName     string
+	}{
+		// Records at the aliased name
+		{name: "example.alias.", qtype: dns.TypeA, answerName: "example.alias."},
+		// Synthetic SOA
+		{name: "example.alias.", qtype: dns.TypeSOA, answerName: "example.alias."},
+		// NODATA with the SOA in the authority section
+		{name: "example.alias.", qtype: dns.TypeTXT, nsName: "example.alias."},
+		// Referral for a delegated name
+		{name: "host.sub.example.alias.", qtype: dns.TypeA, nsName: "sub.example.alias."},
+	}
+	for _, testDef := range testDefs {
+		resp := testQuery(t, testDef.name, testDef.qtype)
+		if resp.Rcode != dns.RcodeSuccess {
+			t.Fatalf(
+				"%s %s: unexpected response code: %s",
+				testDef.name,
+				dns.TypeToString[testDef.qtype],
+				dns.RcodeToString[resp.Rcode],
+			)
+		}
+		if resp.Question[0].Name != testDef.name {
+			t.Errorf("%s: unexpected question name: %s", testDef.name, resp.Question[0].Name)
+		}
+		if testDef.answerName != "" &&
+			(len(resp.Answer) == 0 || resp.Answer[0].Header().Name != testDef.answerName) {
+			t.Errorf(
+				"%s %s: expected answer for %s, got: %v",
+				testDef.name,
+				dns.TypeToString[testDef.qtype],
+				testDef.answerName,
+				resp.Answer,
+			)
+		}
+		if testDef.nsName != "" &&
+			(len(resp.Ns) == 0 || resp.Ns[0].Header().Name != testDef.nsName) {
+			t.Errorf(
+				"%s %s: expected authority record for %s, got: %v",
+				testDef.name,
+				dns.TypeToString[testDef.qtype],
+				testDef.nsName,
+				resp.Ns,
+			)
+		}
+	}
+}
+
+// setupBenchmarkState loads an in-memory state with a number of representative domains, including one
+// with a delegation
+func setupBenchmarkState(b *testing.B) {
+	b.Helper()
+	const domainCount = 1000
+	domains := map[string][]state.DomainRecord{
+		"delegated.test.": {
+			{Lhs: "delegated.test.", Type: "NS", Ttl: 300, Rhs: "ns1.delegated.test."},
+			{Lhs: "delegated.test.", Type: "NS", Ttl: 300, Rhs: "ns2.delegated.test."},
+			{Lhs: "ns1.delegated.test.", Type: "A", Ttl: 300, Rhs: "192.0.2.53"},
+			{Lhs: "ns2.delegated.test.", Type: "A", Ttl: 300, Rhs: "192.0.2.54"},
+		},
+	}
+	for idx := 0; idx < domainCount; idx++ {
+		domainName := fmt.Sprintf("domain%d.test.", idx)
+		domains[domainName] = []state.DomainRecord{
+			{Lhs: domainName, Type: "A", Ttl: 300, Rhs: "192.0.2.1"},
+			{Lhs: domainName, Type: "A", Ttl: 300, Rhs: "192.0.2.2"},
+			{Lhs: domainName, Type: "AAAA", Ttl: 300, Rhs: "2001:db8::1"},
+			{Lhs: domainName, Type: "TXT", Ttl: 300, Rhs: "v=spf1 -all"},
+			{Lhs: domainName, Type: "MX", Ttl: 300, Rhs: "10 mail." + domainName},
+			{Lhs: "www." + domainName, Type: "CNAME", Ttl: 300, Rhs: domainName},
+		}
+	}
+	setupTestState(b, domains)
+}
+
+func BenchmarkHandleQueryLocalHit(b *testing.B) {
+	setupBenchmarkState(b)
+	b.ReportAllocs()
+	b.ResetTimer()
+	for n := 0; n < b.N; n++ {
+		resp := testQuery(b, "domain500.test.", dns.TypeA)
+		if len(resp.Answer) != 2 {
+			b.Fatalf("unexpected answer: %v", resp.Answer)
+		}
+	}
+}
+
+func BenchmarkHandleQueryDelegation(b *testing.B) {
+	setupBenchmarkState(b)
+	b.ReportAllocs()
+	b.ResetTimer()
+	for n := 0; n < b.N; n++ {
+		resp := testQuery(b, "www.delegated.test.", dns.TypeA)
+		if len(resp.Ns) != 2 {
+			b.Fatalf("unexpected referral: %v", resp.Ns)
+		}
+	}
+}
+
+func BenchmarkStateLookupRecords(b *testing.B) {
+	setupBenchmarkState(b)
+	b.ReportAllocs()
+	b.ResetTimer()
+	for n := 0; n < b.N; n++ {
+		records, err := state.GetState().LookupRecords([]string{"A", "AAAA"}, "domain500.test.")
+		if err != nil {
+			b.Fatalf("unexpected error: %s", err)
+		}
+		if len(records) != 3 {
+			b.Fatalf("unexpected records: %v", records)
+		}
+	}
+}
+
+func TestGlueCacheExpiry(t *testing.T) {
+	c := setTestClock(t)
+	cacheSize := 1000
+	cache := newAddressCache(
+		func() int {
+			return cacheSize
+		},
+	)
+	addresses := []net.IP{net.ParseIP("192.0.2.53")}
+	cache.set("ns1.example.test.", addresses, 300)
+	testDefs := []struct {
+		elapsed time.Duration
+		ttl     uint32
+	}{
+		{elapsed: 0, ttl: 300},
+		{elapsed: 299 * time.Second, ttl: 1},
+		{elapsed: 300 * time.Second, ttl: 0},
+		// Expired
+		{elapsed: 301 * time.Second},
+	}
+	start := c.now
+	for _, testDef := range testDefs {
+		c.now = start.Add(testDef.elapsed)
+		cachedAddresses, ttl := cache.get("ns1.example.test.")
+		if found := cachedAddresses != nil; found != (testDef.elapsed <= 300*time.Second) {
+			t.Fatalf("after %s: unexpected cache result: %v", testDef.elapsed, cachedAddresses)
+		}
+		if ttl != testDef.ttl {
+			t.Fatalf("after %s: expected remaining TTL %d, got %d", testDef.elapsed, testDef.ttl, ttl)
+		}
+	}
+	// Expired entries are evicted first when the cache is full
+	cacheSize = 2
+	c.now = start
+	cache.set("ns1.example.test.", addresses, 60)
+	cache.set("ns2.example.test.", addresses, 300)
+	c.now = start.Add(120 * time.Second)
+	cache.set("ns3.example.test.", addresses, 300)
+	for _, name := range []string{"ns2.example.test.", "ns3.example.test."} {
+		if cachedAddresses, _ := cache.get(name); cachedAddresses == nil {
+			t.Fatalf("expected unexpired entry for %s to be kept", name)
+		}
+	}
+}
@@ -0,0 +1,164 @@
+// Copyright 2024 Blink Labs Software
+//
+// Use of this source code is governed by an MIT-style
+// license that can be found in the LICENSE file or at
+// https://opensource.org/licenses/MIT.
+
+package dns
+
+import (
+	"context"
+	"crypto/tls"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"time"
+
+	"github.com/blinklabs-io/cdnsd/internal/config"
+
+	"github.com/miekg/dns"
+	"github.com/quic-go/quic-go"
+)
+
+// DoQ error codes (RFC 9250 section 4.3)
+const (
+	doqNoError       quic.ApplicationErrorCode = 0x0
+	doqInternalError quic.ApplicationErrorCode = 0x1
+	doqProtocolError quic.ApplicationErrorCode = 0x2
+)
+
+// ALPN token for DoQ (RFC 9250 section 4.1.1)
+const doqAlpn = "doq"
+
+// serveQuic runs a DNS-over-QUIC (RFC 9250) listener on the specified address until it fails
+func serveQuic(listenAddr string, tlsConfig *tls.Config) error {
+	cfg := config.GetConfig()
+	quicTlsConfig := tlsConfig.Clone()
+	quicTlsConfig.NextProtos = []string{doqAlpn}
+	quicConfig := &quic.Config{
+		MaxIdleTimeout: cfg.Dns.QuicIdleTimeout,
+	}
+	listener, err := quic.ListenAddr(listenAddr, quicTlsConfig, quicConfig)
+	if err != nil {
+		return err
+	}
+	defer listener.Close()
+	for {
+		conn, err := listener.Accept(context.Background())
+		if err != nil {
+			return fmt.Errorf("failed to accept QUIC connection: %w", err)
+		}
+		go handleQuicConnection(conn)
+	}
+}
+
+func handleQuicConnection(conn quic.Connection) {
+	for {
+		stream, err := conn.AcceptStream(context.Background())
+		if err != nil {
+			// The connection was closed or timed out
+			return
+		}
+		go handleQuicStream(conn, stream)
+	}
+}
+
+func handleQuicStream(conn quic.Connection, stream quic.Stream) {
+	cfg := config.GetConfig()
+	if cfg.Dns.TcpReadTimeout > 0 {
+		_ = stream.SetReadDeadline(time.Now().Add(cfg.Dns.TcpReadTimeout))
+	}
+	// Each stream carries a single query prefixed with a 2-byte length (RFC 9250 section 4.2)
+	var msgLen uint16
+	if err := binary.Read(stream, binary.BigEndian, &msgLen); err != nil {
+		stream.CancelRead(quic.StreamErrorCode(doqProtocolError))
+		stream.CancelWrite(quic.StreamErrorCode(doqProtocolError))
+		return
+	}
+	msgBuf := make([]byte, msgLen)
+	if _, err := io.ReadFull(stream, msgBuf); err != nil {
+		stream.CancelRead(quic.StreamErrorCode(doqProtocolError))
+		stream.CancelWrite(quic.StreamErrorCode(doqProtocolError))
+		return
+	}
+	req := new(dns.Msg)
+	if err := req.Unpack(msgBuf); err != nil {
+		// A malformed query is a protocol error for the whole connection
+		_ = conn.CloseWithError(doqProtocolError, "malformed query")
+		return
+	}
+	// The message ID must be 0 (RFC 9250 section 4.2.1)
+	if req.Id != 0 {
+		_ = conn.CloseWithError(doqProtocolError, "non-zero message ID")
+		return
+	}
+	w := &quicResponseWriter{
+		conn:   conn,
+		stream: stream,
+	}
+	handleQuery(w, req)
+	if !w.written {
+		stream.CancelWrite(quic.StreamErrorCode(doqInternalError))
+		return
+	}
+	_ = stream.Close()
+}
+
+// quicResponseWriter implements dns.ResponseWriter for a DoQ stream
+type quicResponseWriter struct {
+	conn    quic.Connection
+	stream  quic.Stream
+	written bool
+}
+
+func (q *quicResponseWriter) LocalAddr() net.Addr {
+	return q.conn.LocalAddr()
+}
+
+func (q *quicResponseWriter) RemoteAddr() net.Addr {
+	return q.conn.RemoteAddr()
+}
+
+func (q *quicResponseWriter) WriteMsg(m *dns.Msg) error {
+	// The message ID must be 0 (RFC 9250 section 4.2.1)
+	m.Id = 0
+	msgBuf, err := m.Pack()
+	if err != nil {
+		return err
+	}
+	_, err = q.Write(msgBuf)
+	return err
+}
+
+func (q *quicResponseWriter) Write(msgBuf []byte) (int, error) {
+	if q.written {
+		return 0, errors.New("response already written")
+	}
+	if len(msgBuf) > dns.MaxMsgSize {
+		return 0, errors.New("response too large")
+	}
+	q.written = true
+	cfg := config.GetConfig()
+	if cfg.Dns.TcpWriteTimeout > 0 {
+		_ = q.stream.SetWriteDeadline(
+			time.Now().Add(cfg.Dns.TcpWriteTimeout),
+		)
+	}
+	lenBuf := make([]byte, 2, 2+len(msgBuf))
+	binary.BigEndian.PutUint16(lenBuf, uint16(len(msgBuf)))
+	return q.stream.Write(append(lenBuf, msgBuf...))
+}
+
+func (q *quicResponseWriter) Close() error {
+	return q.conn.CloseWithError(doqNoError, "")
+}
+
+func (q *quicResponseWriter) TsigStatus() error {
+	return nil
+}
+
+func (q *quicResponseWriter) TsigTimersOnly(bool) {}
+
+func (q *quicResponseWriter) Hijack() {}
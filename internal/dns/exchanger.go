@@ -0,0 +1,37 @@
+// Copyright 2024 Blink Labs Software
+//
+// Use of this source code is governed by an MIT-style
+// license that can be found in the LICENSE file or at
+// https://opensource.org/licenses/MIT.
+
+package dns
+
+import (
+	"context"
+
+	"github.com/miekg/dns"
+)
+
+// Exchanger sends a query to an upstream server and returns the response
+type Exchanger interface {
+	Exchange(ctx context.Context, msg *dns.Msg, address string) (*dns.Msg, error)
+}
+
+// Exchanger used for all upstream queries. This can be replaced for testing or alternate transports
+var upstreamExchanger Exchanger = &dnsExchanger{}
+
+// SetExchanger replaces the Exchanger used for upstream queries
+func SetExchanger(exchanger Exchanger) {
+	upstreamExchanger = exchanger
+}
+
+// dnsExchanger is the default Exchanger, which uses miekg/dns over UDP
+type dnsExchanger struct{}
+
+func (e *dnsExchanger) Exchange(
+	ctx context.Context,
+	msg *dns.Msg,
+	address string,
+) (*dns.Msg, error) {
+	return dns.ExchangeContext(ctx, msg, address)
+}
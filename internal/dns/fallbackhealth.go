@@ -0,0 +1,152 @@
+// Copyright 2024 Blink Labs Software
+//
+// Use of this source code is governed by an MIT-style
+// license that can be found in the LICENSE file or at
+// https://opensource.org/licenses/MIT.
+
+package dns
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/blinklabs-io/cdnsd/internal/config"
+
+	"github.com/miekg/dns"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+)
+
+const (
+	// Consecutive failed health checks before a fallback server is considered unhealthy
+	fallbackUnhealthyThreshold = 2
+	// Timeout for a health check query when no query timeout is configured
+	fallbackHealthCheckTimeout = 5 * time.Second
+)
+
+var metricFallbackServerHealthy = promauto.NewGaugeVec(prometheus.GaugeOpts{
+	Name: "dns_fallback_server_healthy",
+	Help: "whether a fallback server is passing health checks (1) or not (0)",
+}, []string{"server"})
+
+// fallbackHealth tracks consecutive health check failures for each fallback server
+type fallbackHealth struct {
+	sync.RWMutex
+	failures map[string]int
+}
+
+var globalFallbackHealth = &fallbackHealth{
+	failures: make(map[string]int),
+}
+
+// startFallbackHealthCheck periodically probes the fallback servers in the background, if enabled
+func startFallbackHealthCheck() {
+	cfg := config.GetConfig()
+	if cfg.Dns.FallbackHealthCheckInterval == 0 || len(cfg.Dns.FallbackServers) == 0 {
+		return
+	}
+	for _, server := range cfg.Dns.FallbackServers {
+		metricFallbackServerHealthy.WithLabelValues(server).Set(1)
+	}
+	ticker := time.NewTicker(cfg.Dns.FallbackHealthCheckInterval)
+	go func() {
+		for range ticker.C {
+			globalFallbackHealth.checkServers(cfg.Dns.FallbackServers)
+		}
+	}()
+}
+
+// checkServers probes all of the specified servers in parallel and updates their health
+func (h *fallbackHealth) checkServers(servers []string) {
+	var wg sync.WaitGroup
+	for _, server := range servers {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			h.update(server, probeFallbackServer(server))
+		}()
+	}
+	wg.Wait()
+}
+
+func (h *fallbackHealth) update(server string, probeErr error) {
+	h.Lock()
+	defer h.Unlock()
+	prevFailures := h.failures[server]
+	if probeErr == nil {
+		h.failures[server] = 0
+		if prevFailures >= fallbackUnhealthyThreshold {
+			slog.Info(
+				fmt.Sprintf("fallback server %s has recovered", server),
+			)
+		}
+		metricFallbackServerHealthy.WithLabelValues(server).Set(1)
+		return
+	}
+	h.failures[server] = prevFailures + 1
+	if h.failures[server] == fallbackUnhealthyThreshold {
+		slog.Warn(
+			fmt.Sprintf(
+				"fallback server %s is unhealthy, removing from rotation: %s",
+				server,
+				probeErr,
+			),
+		)
+	}
+	if h.failures[server] >= fallbackUnhealthyThreshold {
+		metricFallbackServerHealthy.WithLabelValues(server).Set(0)
+	}
+}
+
+// healthyServers returns the specified servers that are not currently unhealthy, or all of them if none
+// are healthy
+func (h *fallbackHealth) healthyServers(servers []string) []string {
+	h.RLock()
+	defer h.RUnlock()
+	ret := make([]string, 0, len(servers))
+	for _, server := range servers {
+		if h.failures[server] < fallbackUnhealthyThreshold {
+			ret = append(ret, server)
+		}
+	}
+	if len(ret) == 0 {
+		return servers
+	}
+	return ret
+}
+
+// probeFallbackServer sends the configured health check query to a fallback server
+func probeFallbackServer(server string) error {
+	cfg := config.GetConfig()
+	timeout := cfg.Dns.QueryTimeout
+	if timeout == 0 {
+		timeout = fallbackHealthCheckTimeout
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+	address := server
+	if !strings.Contains(address, ":") {
+		address = address + `:53`
+	}
+	m := new(dns.Msg)
+	m.SetQuestion(dns.Fqdn(cfg.Dns.FallbackHealthCheckName), dns.TypeNS)
+	resp, err := upstreamExchanger.Exchange(ctx, m, address)
+	if err != nil {
+		return err
+	}
+	if resp == nil {
+		return fmt.Errorf("dns response empty")
+	}
+	switch resp.Rcode {
+	case dns.RcodeServerFailure, dns.RcodeRefused:
+		return fmt.Errorf(
+			"unexpected response code: %s",
+			dns.RcodeToString[resp.Rcode],
+		)
+	}
+	return nil
+}
@@ -0,0 +1,186 @@
+// Copyright 2024 Blink Labs Software
+//
+// Use of this source code is governed by an MIT-style
+// license that can be found in the LICENSE file or at
+// https://opensource.org/licenses/MIT.
+
+package dns
+
+import (
+	"context"
+	"net"
+	"sync"
+	"time"
+
+	"github.com/blinklabs-io/cdnsd/internal/config"
+
+	"github.com/miekg/dns"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+)
+
+var (
+	metricGlueCacheHits = promauto.NewCounter(prometheus.CounterOpts{
+		Name: "dns_glue_cache_hits_total",
+		Help: "total nameserver address lookups served from the glue cache",
+	})
+	metricGlueCacheMisses = promauto.NewCounter(prometheus.CounterOpts{
+		Name: "dns_glue_cache_misses_total",
+		Help: "total nameserver address lookups not found in the glue cache",
+	})
+)
+
+type addressCacheEntry struct {
+	addresses []net.IP
+	expires   time.Time
+}
+
+// addressCache holds addresses resolved from upstream servers across queries
+type addressCache struct {
+	sync.Mutex
+	entries map[string]addressCacheEntry
+	// Returns the maximum number of entries, with 0 disabling the cache
+	size func() int
+}
+
+func newAddressCache(size func() int) *addressCache {
+	return &addressCache{
+		entries: make(map[string]addressCacheEntry),
+		size:    size,
+	}
+}
+
+var globalGlueCache = newAddressCache(
+	func() int {
+		return config.GetConfig().Dns.GlueCacheSize
+	},
+)
+
+// get returns the cached addresses for a name along with their remaining TTL
+func (c *addressCache) get(name string) ([]net.IP, uint32) {
+	c.Lock()
+	defer c.Unlock()
+	entry, ok := c.entries[name]
+	if !ok {
+		return nil, 0
+	}
+	now := clock.Now()
+	if now.After(entry.expires) {
+		delete(c.entries, name)
+		return nil, 0
+	}
+	return entry.addresses, uint32(entry.expires.Sub(now) / time.Second)
+}
+
+func (c *addressCache) set(name string, addresses []net.IP, ttl uint32) {
+	size := c.size()
+	if size == 0 || ttl == 0 {
+		return
+	}
+	c.Lock()
+	defer c.Unlock()
+	if _, ok := c.entries[name]; !ok && len(c.entries) >= size {
+		c.evict(size)
+	}
+	c.entries[name] = addressCacheEntry{
+		addresses: addresses,
+		expires:   clock.Now().Add(time.Duration(ttl) * time.Second),
+	}
+}
+
+// evict removes expired entries, or the entry closest to expiring if there are none.
+// This must be called with the lock held
+func (c *addressCache) evict(size int) {
+	now := clock.Now()
+	var oldestName string
+	var oldestExpires time.Time
+	for name, entry := range c.entries {
+		if now.After(entry.expires) {
+			delete(c.entries, name)
+			continue
+		}
+		if oldestName == "" || entry.expires.Before(oldestExpires) {
+			oldestName = name
+			oldestExpires = entry.expires
+		}
+	}
+	if len(c.entries) >= size {
+		delete(c.entries, oldestName)
+	}
+}
+
+// resolveNameserverAddress returns the addresses for a nameserver without glue, using the glue cache
+// when possible and querying the glue resolver or fallback servers otherwise
+func resolveNameserverAddress(
+	ctx context.Context,
+	nameserver string,
+) ([]net.IP, error) {
+	nameserver = dns.CanonicalName(nameserver)
+	if addresses, _ := globalGlueCache.get(nameserver); addresses != nil {
+		metricGlueCacheHits.Inc()
+		return addresses, nil
+	}
+	metricGlueCacheMisses.Inc()
+	addresses, ttl, err := queryAddresses(ctx, nameserver, dns.TypeA)
+	if err != nil {
+		return nil, err
+	}
+	if len(addresses) > 0 {
+		globalGlueCache.set(nameserver, addresses, ttl)
+	}
+	return addresses, nil
+}
+
+// queryAddresses looks up the A or AAAA records for a name using the glue resolver, if configured, or a
+// random fallback server otherwise. It returns the addresses along with their lowest TTL
+func queryAddresses(
+	ctx context.Context,
+	name string,
+	qtype uint16,
+) ([]net.IP, uint32, error) {
+	cfg := config.GetConfig()
+	glueResolver := cfg.Dns.GlueResolver
+	if glueResolver == "" && len(cfg.Dns.FallbackServers) == 0 {
+		return nil, 0, nil
+	}
+	name = dns.CanonicalName(name)
+	m := createQuery(name, qtype)
+	// The glue resolver and fallback servers are recursive resolvers
+	m.RecursionDesired = true
+	resp, err := doQuery(ctx, m, glueResolver, false)
+	if err != nil {
+		return nil, 0, err
+	}
+	// Follow any CNAME records in the answer
+	names := map[string]bool{name: true}
+	for _, answer := range resp.Answer {
+		if v, ok := answer.(*dns.CNAME); ok && names[dns.CanonicalName(v.Hdr.Name)] {
+			names[dns.CanonicalName(v.Target)] = true
+		}
+	}
+	var addresses []net.IP
+	var lowestTtl uint32
+	for _, answer := range resp.Answer {
+		if !names[dns.CanonicalName(answer.Header().Name)] {
+			continue
+		}
+		switch v := answer.(type) {
+		case *dns.A:
+			if qtype != dns.TypeA {
+				continue
+			}
+			addresses = append(addresses, v.A)
+		case *dns.AAAA:
+			if qtype != dns.TypeAAAA {
+				continue
+			}
+			addresses = append(addresses, v.AAAA)
+		default:
+			continue
+		}
+		if lowestTtl == 0 || answer.Header().Ttl < lowestTtl {
+			lowestTtl = answer.Header().Ttl
+		}
+	}
+	return addresses, lowestTtl, nil
+}
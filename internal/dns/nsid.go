@@ -0,0 +1,94 @@
+// Copyright 2024 Blink Labs Software
+//
+// Use of this source code is governed by an MIT-style
+// license that can be found in the LICENSE file or at
+// https://opensource.org/licenses/MIT.
+
+package dns
+
+import (
+	"encoding/hex"
+	"fmt"
+	"log/slog"
+	"os"
+
+	"github.com/blinklabs-io/cdnsd/internal/config"
+
+	"github.com/miekg/dns"
+)
+
+// nsidResponseWriter wraps a dns.ResponseWriter to add the EDNS0 NSID option (RFC 5001) to responses
+// when requested by the client
+type nsidResponseWriter struct {
+	dns.ResponseWriter
+	req  *dns.Msg
+	nsid string
+}
+
+// newNsidResponseWriter returns a wrapped dns.ResponseWriter if NSID is configured and requested
+// by the client, or the original dns.ResponseWriter otherwise
+func newNsidResponseWriter(w dns.ResponseWriter, r *dns.Msg) dns.ResponseWriter {
+	nsid := configuredNsid()
+	if nsid == "" {
+		return w
+	}
+	reqOpt := r.IsEdns0()
+	if reqOpt == nil {
+		return w
+	}
+	for _, option := range reqOpt.Option {
+		if option.Option() == dns.EDNS0NSID {
+			return &nsidResponseWriter{
+				ResponseWriter: w,
+				req:            r,
+				nsid:           nsid,
+			}
+		}
+	}
+	return w
+}
+
+func (n *nsidResponseWriter) WriteMsg(m *dns.Msg) error {
+	respOpt := m.IsEdns0()
+	if respOpt == nil {
+		reqOpt := n.req.IsEdns0()
+		m.SetEdns0(reqOpt.UDPSize(), reqOpt.Do())
+		respOpt = m.IsEdns0()
+	}
+	// Replace any NSID option from an upstream response with our own
+	tmpOptions := make([]dns.EDNS0, 0, len(respOpt.Option)+1)
+	for _, option := range respOpt.Option {
+		if option.Option() == dns.EDNS0NSID {
+			continue
+		}
+		tmpOptions = append(tmpOptions, option)
+	}
+	tmpOptions = append(
+		tmpOptions,
+		&dns.EDNS0_NSID{
+			Code: dns.EDNS0NSID,
+			Nsid: hex.EncodeToString([]byte(n.nsid)),
+		},
+	)
+	respOpt.Option = tmpOptions
+	return n.ResponseWriter.WriteMsg(m)
+}
+
+// configuredNsid returns the NSID value to use, or an empty string if NSID is disabled
+func configuredNsid() string {
+	cfg := config.GetConfig()
+	if cfg.Dns.Nsid != "" {
+		return cfg.Dns.Nsid
+	}
+	if cfg.Dns.NsidHostname {
+		hostname, err := os.Hostname()
+		if err != nil {
+			slog.Error(
+				fmt.Sprintf("failed to get hostname for NSID: %s", err),
+			)
+			return ""
+		}
+		return hostname
+	}
+	return ""
+}
@@ -0,0 +1,93 @@
+// Copyright 2024 Blink Labs Software
+//
+// Use of this source code is governed by an MIT-style
+// license that can be found in the LICENSE file or at
+// https://opensource.org/licenses/MIT.
+
+package dns
+
+import (
+	"context"
+	"fmt"
+	"math/rand"
+	"net"
+
+	"github.com/blinklabs-io/cdnsd/internal/config"
+
+	"github.com/miekg/dns"
+)
+
+// nameserverQueryCount returns the number of nameservers to query in parallel
+func nameserverQueryCount() int {
+	cfg := config.GetConfig()
+	if cfg.Dns.ParallelNameserverQueries > 1 {
+		return cfg.Dns.ParallelNameserverQueries
+	}
+	return 1
+}
+
+// randomNameserverAddresses returns up to count distinct nameserver addresses in random order
+func randomNameserverAddresses(nameservers map[string][]net.IP, count int) []string {
+	// Put all nameserver addresses in single list
+	tmpAddresses := []string{}
+	seen := map[string]bool{}
+	for _, addresses := range nameservers {
+		for _, address := range addresses {
+			tmpAddress := address.String()
+			if seen[tmpAddress] {
+				continue
+			}
+			seen[tmpAddress] = true
+			tmpAddresses = append(tmpAddresses, tmpAddress)
+		}
+	}
+	rand.Shuffle(
+		len(tmpAddresses),
+		func(i, j int) {
+			tmpAddresses[i], tmpAddresses[j] = tmpAddresses[j], tmpAddresses[i]
+		},
+	)
+	if len(tmpAddresses) > count {
+		tmpAddresses = tmpAddresses[:count]
+	}
+	return tmpAddresses
+}
+
+// doParallelQuery queries all of the specified addresses in parallel and returns the first successful
+// response. The remaining queries are cancelled once a response is received
+func doParallelQuery(
+	ctx context.Context,
+	msg *dns.Msg,
+	addresses []string,
+	recursive bool,
+) (*dns.Msg, error) {
+	if len(addresses) == 0 {
+		return nil, fmt.Errorf("no nameserver addresses to query")
+	}
+	if len(addresses) == 1 {
+		return doQuery(ctx, msg, addresses[0], recursive)
+	}
+	queryCtx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	type queryResult struct {
+		resp *dns.Msg
+		err  error
+	}
+	// This is buffered so that the remaining goroutines don't block after we return
+	resultChan := make(chan queryResult, len(addresses))
+	for _, address := range addresses {
+		go func(address string) {
+			resp, err := doQuery(queryCtx, msg.Copy(), address, recursive)
+			resultChan <- queryResult{resp: resp, err: err}
+		}(address)
+	}
+	var lastErr error
+	for i := 0; i < len(addresses); i++ {
+		result := <-resultChan
+		if result.err == nil {
+			return result.resp, nil
+		}
+		lastErr = result.err
+	}
+	return nil, lastErr
+}
@@ -0,0 +1,214 @@
+// Copyright 2024 Blink Labs Software
+//
+// Use of this source code is governed by an MIT-style
+// license that can be found in the LICENSE file or at
+// https://opensource.org/licenses/MIT.
+
+package dns
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	// Maximum length of a PROXY protocol v1 header, including the CRLF
+	proxyV1MaxLength = 107
+	// Time allowed for a client to send the PROXY protocol header
+	proxyHeaderTimeout = 5 * time.Second
+)
+
+// PROXY protocol v2 signature
+var proxyV2Signature = []byte("\r\n\r\n\x00\r\nQUIT\n")
+
+// Networks of proxies trusted to send a PROXY protocol header
+var proxyTrustedNets []*net.IPNet
+
+// proxyListener wraps a TCP listener to recover the original client address from the PROXY protocol
+// (v1 or v2) header sent by trusted proxies
+type proxyListener struct {
+	net.Listener
+}
+
+func newProxyListener(listener net.Listener) net.Listener {
+	return &proxyListener{Listener: listener}
+}
+
+func (l *proxyListener) Accept() (net.Conn, error) {
+	conn, err := l.Listener.Accept()
+	if err != nil {
+		return nil, err
+	}
+	// Connections from untrusted sources are used as-is
+	tcpAddr, ok := conn.RemoteAddr().(*net.TCPAddr)
+	if !ok || !proxyTrusted(tcpAddr.IP) {
+		return conn, nil
+	}
+	return &proxyConn{
+		Conn:   conn,
+		reader: bufio.NewReader(conn),
+	}, nil
+}
+
+func proxyTrusted(ip net.IP) bool {
+	for _, tmpNet := range proxyTrustedNets {
+		if tmpNet.Contains(ip) {
+			return true
+		}
+	}
+	return false
+}
+
+// proxyConn reads the PROXY protocol header on first use, so that a slow client doesn't block Accept
+type proxyConn struct {
+	net.Conn
+	reader     *bufio.Reader
+	once       sync.Once
+	headerErr  error
+	remoteAddr net.Addr
+}
+
+func (c *proxyConn) Read(b []byte) (int, error) {
+	c.once.Do(c.readHeader)
+	if c.headerErr != nil {
+		return 0, c.headerErr
+	}
+	return c.reader.Read(b)
+}
+
+func (c *proxyConn) RemoteAddr() net.Addr {
+	c.once.Do(c.readHeader)
+	if c.remoteAddr != nil {
+		return c.remoteAddr
+	}
+	return c.Conn.RemoteAddr()
+}
+
+func (c *proxyConn) readHeader() {
+	if err := c.Conn.SetReadDeadline(time.Now().Add(proxyHeaderTimeout)); err != nil {
+		c.headerErr = err
+		return
+	}
+	c.remoteAddr, c.headerErr = readProxyHeader(c.reader)
+	if c.headerErr != nil {
+		c.headerErr = fmt.Errorf("invalid PROXY protocol header: %w", c.headerErr)
+		_ = c.Conn.Close()
+		return
+	}
+	c.headerErr = c.Conn.SetReadDeadline(time.Time{})
+}
+
+// readProxyHeader reads a PROXY protocol v1 or v2 header and returns the original client address. A nil
+// address is returned for connections that the proxy reports as its own (LOCAL or UNKNOWN)
+func readProxyHeader(r *bufio.Reader) (net.Addr, error) {
+	sig, err := r.Peek(len(proxyV2Signature))
+	if err != nil {
+		return nil, err
+	}
+	if bytes.Equal(sig, proxyV2Signature) {
+		return readProxyV2Header(r)
+	}
+	return readProxyV1Header(r)
+}
+
+func readProxyV1Header(r *bufio.Reader) (net.Addr, error) {
+	var line []byte
+	for {
+		b, err := r.ReadByte()
+		if err != nil {
+			return nil, err
+		}
+		line = append(line, b)
+		if b == '\n' {
+			break
+		}
+		if len(line) >= proxyV1MaxLength {
+			return nil, errors.New("v1 header too long")
+		}
+	}
+	if !bytes.HasSuffix(line, []byte("\r\n")) {
+		return nil, errors.New("v1 header missing CRLF")
+	}
+	// PROXY <proto> <src addr> <dst addr> <src port> <dst port>
+	fields := strings.Split(string(line[:len(line)-2]), " ")
+	if len(fields) < 2 || fields[0] != "PROXY" {
+		return nil, errors.New("missing v1 header")
+	}
+	switch fields[1] {
+	case "UNKNOWN":
+		return nil, nil
+	case "TCP4", "TCP6":
+	default:
+		return nil, fmt.Errorf("unsupported v1 protocol: %s", fields[1])
+	}
+	if len(fields) != 6 {
+		return nil, errors.New("malformed v1 header")
+	}
+	srcIp := net.ParseIP(fields[2])
+	if srcIp == nil {
+		return nil, fmt.Errorf("invalid v1 source address: %s", fields[2])
+	}
+	srcPort, err := strconv.ParseUint(fields[4], 10, 16)
+	if err != nil {
+		return nil, fmt.Errorf("invalid v1 source port: %s", fields[4])
+	}
+	return &net.TCPAddr{IP: srcIp, Port: int(srcPort)}, nil
+}
+
+func readProxyV2Header(r *bufio.Reader) (net.Addr, error) {
+	header := make([]byte, len(proxyV2Signature)+4)
+	if _, err := io.ReadFull(r, header); err != nil {
+		return nil, err
+	}
+	verCmd := header[len(proxyV2Signature)]
+	famProto := header[len(proxyV2Signature)+1]
+	addrLen := binary.BigEndian.Uint16(header[len(proxyV2Signature)+2:])
+	addrData := make([]byte, addrLen)
+	if _, err := io.ReadFull(r, addrData); err != nil {
+		return nil, err
+	}
+	if verCmd>>4 != 2 {
+		return nil, fmt.Errorf("unsupported v2 version: %d", verCmd>>4)
+	}
+	switch verCmd & 0x0f {
+	case 0x0:
+		// LOCAL command, such as health checks from the proxy itself
+		return nil, nil
+	case 0x1:
+		// PROXY command
+	default:
+		return nil, fmt.Errorf("unsupported v2 command: %d", verCmd&0x0f)
+	}
+	switch famProto {
+	case 0x11:
+		// TCP over IPv4
+		if len(addrData) < 12 {
+			return nil, errors.New("short v2 IPv4 address block")
+		}
+		return &net.TCPAddr{
+			IP:   net.IP(addrData[0:4]),
+			Port: int(binary.BigEndian.Uint16(addrData[8:10])),
+		}, nil
+	case 0x21:
+		// TCP over IPv6
+		if len(addrData) < 36 {
+			return nil, errors.New("short v2 IPv6 address block")
+		}
+		return &net.TCPAddr{
+			IP:   net.IP(addrData[0:16]),
+			Port: int(binary.BigEndian.Uint16(addrData[32:34])),
+		}, nil
+	default:
+		// Other address families are treated as coming from the proxy itself
+		return nil, nil
+	}
+}
@@ -0,0 +1,74 @@
+// Copyright 2024 Blink Labs Software
+//
+// Use of this source code is governed by an MIT-style
+// license that can be found in the LICENSE file or at
+// https://opensource.org/licenses/MIT.
+
+package dns
+
+import (
+	"log/slog"
+	"time"
+
+	"github.com/miekg/dns"
+)
+
+// queryLogResponseWriter wraps a dns.ResponseWriter to capture details about the response for the query log
+type queryLogResponseWriter struct {
+	dns.ResponseWriter
+	startTime time.Time
+	rcode     int
+	source    string
+	written   bool
+}
+
+func newQueryLogResponseWriter(w dns.ResponseWriter) *queryLogResponseWriter {
+	return &queryLogResponseWriter{
+		ResponseWriter: w,
+		startTime:      time.Now(),
+	}
+}
+
+func (q *queryLogResponseWriter) WriteMsg(m *dns.Msg) error {
+	q.rcode = m.Rcode
+	q.written = true
+	return q.ResponseWriter.WriteMsg(m)
+}
+
+// logQuery writes a structured query log entry for the specified request
+func (q *queryLogResponseWriter) logQuery(r *dns.Msg) {
+	rcode := "NONE"
+	if q.written {
+		rcode = dns.RcodeToString[q.rcode]
+	}
+	source := q.source
+	if source == "" {
+		source = "none"
+	}
+	var client, protocol string
+	if remoteAddr := q.RemoteAddr(); remoteAddr != nil {
+		client = remoteAddr.String()
+		protocol = remoteAddr.Network()
+	}
+	for _, question := range r.Question {
+		slog.Info(
+			"query",
+			"name", question.Name,
+			"type", dns.Type(question.Qtype).String(),
+			"class", dns.Class(question.Qclass).String(),
+			"client", client,
+			"protocol", protocol,
+			"rcode", rcode,
+			"source", source,
+			"latency_ms", time.Since(q.startTime).Milliseconds(),
+		)
+	}
+}
+
+// countAnswer increments the answers metric for the specified source and records the source for the query log
+func countAnswer(w dns.ResponseWriter, source string) {
+	metricAnswersTotal.WithLabelValues(source).Inc()
+	if queryLogWriter, ok := w.(*queryLogResponseWriter); ok {
+		queryLogWriter.source = source
+	}
+}
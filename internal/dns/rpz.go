@@ -0,0 +1,89 @@
+// Copyright 2024 Blink Labs Software
+//
+// Use of this source code is governed by an MIT-style
+// license that can be found in the LICENSE file or at
+// https://opensource.org/licenses/MIT.
+
+package dns
+
+import (
+	"strings"
+
+	"github.com/blinklabs-io/cdnsd/internal/config"
+
+	"github.com/miekg/dns"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+)
+
+// TTL for synthesized RPZ responses
+const rpzTtl = 60
+
+var (
+	metricRpzHitsTotal = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "dns_rpz_hits_total",
+		Help: "total queries matching a response policy rule, by action",
+	}, []string{"action"})
+)
+
+// findRpzRule returns the response policy rule matching the specified query name, if any. Exact matches take
+// precedence over wildcard ("*.example.com") matches, and more specific wildcards take precedence over less specific ones
+func findRpzRule(name string) *config.RpzRuleConfig {
+	cfg := config.GetConfig()
+	name = dns.CanonicalName(name)
+	var ret *config.RpzRuleConfig
+	retLabels := -1
+	for idx, rule := range cfg.Dns.Rpz {
+		ruleName := dns.CanonicalName(rule.Name)
+		if ruleName == name {
+			return &cfg.Dns.Rpz[idx]
+		}
+		zoneName, ok := strings.CutPrefix(ruleName, "*.")
+		if !ok {
+			continue
+		}
+		// Wildcards only match names below the zone
+		if zoneName == name || !dns.IsSubDomain(zoneName, name) {
+			continue
+		}
+		if zoneLabels := dns.CountLabel(zoneName); zoneLabels > retLabels {
+			ret = &cfg.Dns.Rpz[idx]
+			retLabels = zoneLabels
+		}
+	}
+	return ret
+}
+
+// applyRpz applies any matching response policy rule to the response for the specified request. It returns
+// true if the response was handled by a policy rule
+func applyRpz(r *dns.Msg, m *dns.Msg) bool {
+	rule := findRpzRule(r.Question[0].Name)
+	if rule == nil {
+		return false
+	}
+	metricRpzHitsTotal.WithLabelValues(rule.Action).Inc()
+	switch rule.Action {
+	case config.RpzActionNxdomain:
+		m.SetRcode(r, dns.RcodeNameError)
+	case config.RpzActionNodata:
+		m.SetReply(r)
+	case config.RpzActionCname:
+		m.SetReply(r)
+		m.Answer = append(
+			m.Answer,
+			&dns.CNAME{
+				Hdr: dns.RR_Header{
+					Name:   r.Question[0].Name,
+					Rrtype: dns.TypeCNAME,
+					Class:  dns.ClassINET,
+					Ttl:    rpzTtl,
+				},
+				Target: dns.Fqdn(rule.Target),
+			},
+		)
+	default:
+		// Pass through to normal query handling
+		return false
+	}
+	return true
+}
@@ -0,0 +1,56 @@
+// Copyright 2024 Blink Labs Software
+//
+// Use of this source code is governed by an MIT-style
+// license that can be found in the LICENSE file or at
+// https://opensource.org/licenses/MIT.
+
+package dns
+
+import (
+	"github.com/miekg/dns"
+)
+
+// svcbAdditionalRecords returns the A/AAAA records from local storage for the targets of any SVCB or
+// HTTPS records in the answers, for use in the additional section (RFC 9460, section 4.1)
+func svcbAdditionalRecords(answers []dns.RR) ([]dns.RR, error) {
+	var ret []dns.RR
+	seen := map[string]bool{}
+	for _, answer := range answers {
+		var svcb *dns.SVCB
+		switch v := answer.(type) {
+		case *dns.SVCB:
+			svcb = v
+		case *dns.HTTPS:
+			svcb = &v.SVCB
+		default:
+			continue
+		}
+		target := svcb.Target
+		if target == "." {
+			// A target of "." means the owner name in ServiceMode and that the service is unavailable
+			// in AliasMode
+			if svcb.Priority == 0 {
+				continue
+			}
+			target = svcb.Hdr.Name
+		}
+		target = dns.CanonicalName(target)
+		if seen[target] {
+			continue
+		}
+		seen[target] = true
+		for _, qtype := range []uint16{dns.TypeA, dns.TypeAAAA} {
+			records, err := lookupLocalRecords(target, qtype)
+			if err != nil {
+				return nil, err
+			}
+			for _, record := range records {
+				// Skip any CNAME returned for the target
+				if record.Header().Rrtype == qtype {
+					ret = append(ret, record)
+				}
+			}
+		}
+	}
+	return ret, nil
+}
@@ -0,0 +1,124 @@
+// Copyright 2024 Blink Labs Software
+//
+// Use of this source code is governed by an MIT-style
+// license that can be found in the LICENSE file or at
+// https://opensource.org/licenses/MIT.
+
+package dns
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/miekg/dns"
+)
+
+// Maximum number of aliases followed when resolving a TLD alias
+const maxTldAliasDepth = 8
+
+// Configured TLD aliases, keyed by canonical alias TLD
+var tldAliases map[string]string
+
+// loadTldAliases parses the configured TLD aliases
+func loadTldAliases(aliases map[string]string) error {
+	tmpAliases := map[string]string{}
+	for alias, target := range aliases {
+		aliasName := dns.CanonicalName(alias)
+		targetName := dns.CanonicalName(target)
+		if dns.CountLabel(aliasName) != 1 || dns.CountLabel(targetName) != 1 {
+			return fmt.Errorf("invalid TLD alias %s: %s", alias, target)
+		}
+		tmpAliases[aliasName] = targetName
+	}
+	tldAliases = tmpAliases
+	return nil
+}
+
+// resolveTldAlias returns the name to lookup in local storage for the specified name, following any
+// configured TLD aliases
+func resolveTldAlias(name string) (string, error) {
+	if len(tldAliases) == 0 {
+		return name, nil
+	}
+	labels := dns.SplitDomainName(name)
+	if len(labels) == 0 {
+		return name, nil
+	}
+	tld := dns.CanonicalName(labels[len(labels)-1])
+	if _, ok := tldAliases[tld]; !ok {
+		return name, nil
+	}
+	seen := map[string]bool{}
+	for {
+		target, ok := tldAliases[tld]
+		if !ok {
+			break
+		}
+		if seen[tld] || len(seen) >= maxTldAliasDepth {
+			return "", fmt.Errorf("TLD alias loop detected for %s", name)
+		}
+		seen[tld] = true
+		tld = target
+	}
+	labels[len(labels)-1] = strings.TrimSuffix(tld, ".")
+	return dns.Fqdn(strings.Join(labels, ".")), nil
+}
+
+// tldAliasResponseWriter wraps a dns.ResponseWriter to present a response built for the name under the
+// target TLD as a response for the queried name under the alias TLD
+type tldAliasResponseWriter struct {
+	dns.ResponseWriter
+	queryName  string
+	lookupName string
+}
+
+// newTldAliasResponseWriter returns a wrapped dns.ResponseWriter and request to use for a query under an
+// aliased TLD, or the originals otherwise
+func newTldAliasResponseWriter(
+	w dns.ResponseWriter,
+	r *dns.Msg,
+) (dns.ResponseWriter, *dns.Msg, error) {
+	lookupName, err := resolveTldAlias(r.Question[0].Name)
+	if err != nil {
+		return nil, nil, err
+	}
+	if lookupName == r.Question[0].Name {
+		return w, r, nil
+	}
+	lookupReq := r.Copy()
+	lookupReq.Question[0].Name = lookupName
+	return &tldAliasResponseWriter{
+			ResponseWriter: w,
+			queryName:      r.Question[0].Name,
+			lookupName:     lookupName,
+		},
+		lookupReq,
+		nil
+}
+
+func (t *tldAliasResponseWriter) WriteMsg(m *dns.Msg) error {
+	m = m.Copy()
+	for idx := range m.Question {
+		m.Question[idx].Name = t.rewriteName(m.Question[idx].Name)
+	}
+	for _, section := range [][]dns.RR{m.Answer, m.Ns, m.Extra} {
+		for _, rr := range section {
+			if rr.Header().Rrtype == dns.TypeOPT {
+				continue
+			}
+			rr.Header().Name = t.rewriteName(rr.Header().Name)
+		}
+	}
+	return t.ResponseWriter.WriteMsg(m)
+}
+
+// rewriteName returns the name under the alias TLD for the lookup name or any of its parents, such as the
+// owner of a zone cut or SOA record. Other names are returned as-is
+func (t *tldAliasResponseWriter) rewriteName(name string) string {
+	if name == "." || !dns.IsSubDomain(name, t.lookupName) {
+		return name
+	}
+	queryLabels := dns.SplitDomainName(t.queryName)
+	nameLabelCount := dns.CountLabel(name)
+	return dns.Fqdn(strings.Join(queryLabels[len(queryLabels)-nameLabelCount:], "."))
+}
@@ -0,0 +1,101 @@
+// Copyright 2024 Blink Labs Software
+//
+// Use of this source code is governed by an MIT-style
+// license that can be found in the LICENSE file or at
+// https://opensource.org/licenses/MIT.
+
+package dns
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/blinklabs-io/cdnsd/internal/config"
+	"github.com/blinklabs-io/cdnsd/internal/state"
+
+	"github.com/miekg/dns"
+)
+
+// Static records served at the apex of blockchain TLDs, keyed by canonical TLD name
+var tldApexRecords map[string][]dns.RR
+
+// loadTldApexRecords parses the configured TLD apex records
+func loadTldApexRecords(tldRecords map[string][]string) error {
+	tmpRecords := map[string][]dns.RR{}
+	for tld, records := range tldRecords {
+		tldName := dns.CanonicalName(tld)
+		if dns.CountLabel(tldName) != 1 {
+			return fmt.Errorf("invalid TLD for apex records: %s", tld)
+		}
+		for _, record := range records {
+			// Records are specified without an owner name, with an optional TTL (e.g. "300 A 192.0.2.1")
+			tmpRR, err := dns.NewRR(fmt.Sprintf("%s %s", tldName, record))
+			if err != nil {
+				return fmt.Errorf(
+					"invalid apex record for TLD %s: %w",
+					tld,
+					err,
+				)
+			}
+			if tmpRR == nil {
+				return fmt.Errorf("empty apex record for TLD %s", tld)
+			}
+			switch tmpRR.Header().Rrtype {
+			case dns.TypeA, dns.TypeAAAA, dns.TypeTXT:
+			default:
+				return fmt.Errorf(
+					"unsupported apex record type for TLD %s: %s",
+					tld,
+					dns.Type(tmpRR.Header().Rrtype).String(),
+				)
+			}
+			tmpRecords[tldName] = append(tmpRecords[tldName], tmpRR)
+		}
+	}
+	tldApexRecords = tmpRecords
+	return nil
+}
+
+// lookupTldApexRecords returns the configured static records for the apex of a blockchain TLD, if any
+func lookupTldApexRecords(name string, qtype uint16) ([]dns.RR, error) {
+	name = dns.CanonicalName(name)
+	records, ok := tldApexRecords[name]
+	if !ok {
+		return nil, nil
+	}
+	// Only serve records for TLDs we're authoritative for
+	isBlockchainTld, err := isBlockchainTld(name)
+	if err != nil {
+		return nil, err
+	}
+	if !isBlockchainTld {
+		return nil, nil
+	}
+	var ret []dns.RR
+	for _, record := range records {
+		if qtype == dns.TypeANY || record.Header().Rrtype == qtype {
+			ret = append(ret, dns.Copy(record))
+		}
+	}
+	return ret, nil
+}
+
+// isBlockchainTld returns whether the specified TLD comes from a configured profile or a discovered address
+func isBlockchainTld(tld string) (bool, error) {
+	tld = strings.Trim(tld, ".")
+	for _, profile := range config.GetProfiles() {
+		if strings.EqualFold(profile.Tld, tld) {
+			return true, nil
+		}
+	}
+	discoveredAddrs, err := state.GetState().GetDiscoveredAddresses()
+	if err != nil {
+		return false, err
+	}
+	for _, discoveredAddr := range discoveredAddrs {
+		if strings.EqualFold(discoveredAddr.TldName, tld) {
+			return true, nil
+		}
+	}
+	return false, nil
+}
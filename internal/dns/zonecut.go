@@ -0,0 +1,224 @@
+// Copyright 2024 Blink Labs Software
+//
+// Use of this source code is governed by an MIT-style
+// license that can be found in the LICENSE file or at
+// https://opensource.org/licenses/MIT.
+
+package dns
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"net"
+	"slices"
+	"strings"
+
+	"github.com/blinklabs-io/cdnsd/internal/config"
+	"github.com/blinklabs-io/cdnsd/internal/state"
+
+	"github.com/miekg/dns"
+)
+
+// ZoneCut represents a delegation found for a query name, with the records needed to answer with a
+// referral or to query the delegated nameservers
+type ZoneCut struct {
+	// Name of the delegated zone
+	Zone string
+	// Source of the delegation, such as "cardano"
+	Source string
+	// NS records for the zone
+	Nameservers []*dns.NS
+	// A/AAAA glue records for the nameservers from the same source as the delegation
+	Glue []dns.RR
+	// A/AAAA records for nameservers without glue, resolved from elsewhere
+	Resolved []dns.RR
+}
+
+// findZoneCut returns the closest delegation for the specified name from local storage, or nil if there
+// is none. TTLs are taken from on-chain records when available, falling back to the configured
+// delegation TTL. Addresses for nameservers without glue are only resolved when resolveMissing is set,
+// since a referral doesn't need them
+func findZoneCut(
+	ctx context.Context,
+	recordName string,
+	resolveMissing bool,
+) (*ZoneCut, error) {
+	cfg := config.GetConfig()
+	// Split record name into labels and lookup each domain and parent until we get a hit
+	queryLabels := dns.SplitDomainName(recordName)
+
+	// Special case for root domain
+	if queryLabels == nil {
+		queryLabels = append(queryLabels, "")
+	}
+
+	// Check on-chain domains first
+	for startLabelIdx := 0; startLabelIdx < len(queryLabels); startLabelIdx++ {
+		lookupDomainName := strings.Join(queryLabels[startLabelIdx:], ".")
+		// Convert to canonical form for consistency
+		lookupDomainName = dns.CanonicalName(lookupDomainName)
+		nsRecords, err := state.GetState().
+			LookupRecords([]string{"NS"}, lookupDomainName)
+		if err != nil {
+			return nil, err
+		}
+		if len(nsRecords) == 0 {
+			continue
+		}
+		zoneCut := &ZoneCut{
+			Zone:   dns.Fqdn(lookupDomainName),
+			Source: answerSourceCardano,
+		}
+		// The NS RRset uses the lowest on-chain TTL
+		var nsTtl uint32
+		for _, nsRecord := range nsRecords {
+			nsTtl = minTtl(nsTtl, nsRecord.Ttl)
+		}
+		if nsTtl == 0 {
+			nsTtl = cfg.Dns.DelegationTtl
+		}
+		for _, nsRecord := range nsRecords {
+			nameserver := dns.Fqdn(nsRecord.Rhs)
+			zoneCut.Nameservers = append(
+				zoneCut.Nameservers,
+				&dns.NS{
+					Hdr: dns.RR_Header{Name: zoneCut.Zone, Rrtype: dns.TypeNS, Class: dns.ClassINET, Ttl: nsTtl},
+					Ns:  nameserver,
+				},
+			)
+			// Get matching A/AAAA records for NS entry
+			aRecords, err := state.GetState().
+				LookupRecords([]string{"A", "AAAA"}, nsRecord.Rhs)
+			if err != nil {
+				return nil, err
+			}
+			if len(aRecords) > 0 {
+				// The glue RRset for each nameserver uses the lowest on-chain TTL
+				var glueTtl uint32
+				for _, aRecord := range aRecords {
+					glueTtl = minTtl(glueTtl, aRecord.Ttl)
+				}
+				if glueTtl == 0 {
+					glueTtl = cfg.Dns.DelegationTtl
+				}
+				for _, aRecord := range aRecords {
+					address := net.ParseIP(aRecord.Rhs)
+					if address == nil {
+						continue
+					}
+					zoneCut.Glue = append(
+						zoneCut.Glue,
+						addressRecord(nameserver, address, glueTtl),
+					)
+				}
+				continue
+			}
+			if !resolveMissing {
+				continue
+			}
+			// Resolve nameservers without on-chain glue
+			addresses, err := resolveNameserverAddress(ctx, nsRecord.Rhs)
+			if err != nil {
+				slog.Warn(
+					fmt.Sprintf(
+						"failed to resolve address for nameserver %s: %s",
+						nsRecord.Rhs,
+						err,
+					),
+				)
+			}
+			for _, address := range addresses {
+				zoneCut.Resolved = append(
+					zoneCut.Resolved,
+					addressRecord(nameserver, address, cfg.Dns.DelegationTtl),
+				)
+			}
+		}
+		slog.Debug(
+			fmt.Sprintf(
+				"found zone cut %s for %s from %s with %d nameserver(s)",
+				zoneCut.Zone,
+				recordName,
+				zoneCut.Source,
+				len(zoneCut.Nameservers),
+			),
+		)
+		return zoneCut, nil
+	}
+
+	return nil, nil
+}
+
+// addresses returns the known addresses for each nameserver, including nameservers with no addresses
+func (z *ZoneCut) addresses() map[string][]net.IP {
+	ret := map[string][]net.IP{}
+	for _, ns := range z.Nameservers {
+		if _, ok := ret[ns.Ns]; !ok {
+			ret[ns.Ns] = []net.IP{}
+		}
+	}
+	for _, record := range slices.Concat(z.Glue, z.Resolved) {
+		switch v := record.(type) {
+		case *dns.A:
+			ret[v.Hdr.Name] = append(ret[v.Hdr.Name], v.A)
+		case *dns.AAAA:
+			ret[v.Hdr.Name] = append(ret[v.Hdr.Name], v.AAAA)
+		}
+	}
+	return ret
+}
+
+// selfContained returns whether all nameservers are within the delegated zone and have glue
+func (z *ZoneCut) selfContained() bool {
+	hasGlue := map[string]bool{}
+	for _, record := range z.Glue {
+		hasGlue[record.Header().Name] = true
+	}
+	for _, ns := range z.Nameservers {
+		if !dns.IsSubDomain(z.Zone, ns.Ns) || !hasGlue[ns.Ns] {
+			return false
+		}
+	}
+	return len(z.Nameservers) > 0
+}
+
+// addReferral adds the NS records to the authority section and the nameserver addresses to the
+// additional section of the response. Only glue for nameservers within the delegated zone is added in
+// minimal responses mode
+func (z *ZoneCut) addReferral(m *dns.Msg, minimal bool) {
+	for _, ns := range z.Nameservers {
+		m.Ns = append(m.Ns, ns)
+	}
+	for _, record := range slices.Concat(z.Glue, z.Resolved) {
+		if minimal && !dns.IsSubDomain(z.Zone, record.Header().Name) {
+			continue
+		}
+		m.Extra = append(m.Extra, record)
+	}
+}
+
+// addressRecord returns an A or AAAA record for the address
+func addressRecord(name string, address net.IP, ttl uint32) dns.RR {
+	if address.To4() != nil {
+		return &dns.A{
+			Hdr: dns.RR_Header{Name: name, Rrtype: dns.TypeA, Class: dns.ClassINET, Ttl: ttl},
+			A:   address,
+		}
+	}
+	return &dns.AAAA{
+		Hdr:  dns.RR_Header{Name: name, Rrtype: dns.TypeAAAA, Class: dns.ClassINET, Ttl: ttl},
+		AAAA: address,
+	}
+}
+
+// minTtl returns the lower of the current TTL and a record TTL, ignoring unspecified (0) values
+func minTtl(current uint32, recordTtl int) uint32 {
+	if recordTtl <= 0 {
+		return current
+	}
+	if current == 0 || uint32(recordTtl) < current {
+		return uint32(recordTtl)
+	}
+	return current
+}
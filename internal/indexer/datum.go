@@ -9,6 +9,7 @@ package indexer
 import (
 	"fmt"
 
+	models "github.com/blinklabs-io/cardano-models"
 	"github.com/blinklabs-io/gouroboros/cbor"
 )
 
@@ -58,3 +59,92 @@ func (d *DNSReferenceRefScriptDatum) UnmarshalCBOR(cborData []byte) error {
 	}
 	return cbor.DecodeGeneric(tmpDataInner.FieldsCbor(), d)
 }
+
+// CardanoDnsDomainDatum represents a DNS domain datum. It's compatible with models.CardanoDnsDomain, but
+// also allows for optional per-record metadata
+type CardanoDnsDomainDatum struct {
+	Origin  []byte
+	Records []CardanoDnsDomainRecordDatum
+}
+
+func (d *CardanoDnsDomainDatum) UnmarshalCBOR(cborData []byte) error {
+	var tmpData cbor.Constructor
+	if _, err := cbor.Decode(cborData, &tmpData); err != nil {
+		return err
+	}
+	if tmpData.Constructor() != 1 {
+		return fmt.Errorf(
+			"unexpected constructor index: %d",
+			tmpData.Constructor(),
+		)
+	}
+	var tmpFields []cbor.RawMessage
+	if _, err := cbor.Decode(tmpData.FieldsCbor(), &tmpFields); err != nil {
+		return err
+	}
+	if len(tmpFields) < 2 {
+		return fmt.Errorf(
+			"unexpected field count: expected at least 2, got %d",
+			len(tmpFields),
+		)
+	}
+	if _, err := cbor.Decode(tmpFields[0], &d.Origin); err != nil {
+		return err
+	}
+	if _, err := cbor.Decode(tmpFields[1], &d.Records); err != nil {
+		return err
+	}
+	return nil
+}
+
+// CardanoDnsDomainRecordDatum represents a DNS record within a domain datum. The first four fields
+// match models.CardanoDnsDomainRecord, and the remaining optional fields carry extra metadata
+type CardanoDnsDomainRecordDatum struct {
+	Lhs     []byte
+	Ttl     models.CardanoDnsMaybe[models.CardanoDnsTtl]
+	Type    []byte
+	Rhs     []byte
+	Class   models.CardanoDnsMaybe[[]byte]
+	Comment models.CardanoDnsMaybe[[]byte]
+}
+
+func (r *CardanoDnsDomainRecordDatum) UnmarshalCBOR(cborData []byte) error {
+	var tmpData cbor.Constructor
+	if _, err := cbor.Decode(cborData, &tmpData); err != nil {
+		return err
+	}
+	if tmpData.Constructor() != 1 {
+		return fmt.Errorf(
+			"unexpected constructor index: %d",
+			tmpData.Constructor(),
+		)
+	}
+	var tmpFields []cbor.RawMessage
+	if _, err := cbor.Decode(tmpData.FieldsCbor(), &tmpFields); err != nil {
+		return err
+	}
+	if len(tmpFields) < 4 {
+		return fmt.Errorf(
+			"unexpected field count: expected at least 4, got %d",
+			len(tmpFields),
+		)
+	}
+	fieldDests := []any{
+		&r.Lhs,
+		&r.Ttl,
+		&r.Type,
+		&r.Rhs,
+		&r.Class,
+		&r.Comment,
+	}
+	for idx, tmpField := range tmpFields {
+		// Ignore any unknown trailing fields
+		if idx >= len(fieldDests) {
+			break
+		}
+		if _, err := cbor.Decode(tmpField, fieldDests[idx]); err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -7,11 +7,15 @@
 package indexer
 
 import (
+	"bytes"
 	"encoding/hex"
 	"fmt"
 	"log/slog"
-	"os"
+	"maps"
+	"slices"
 	"strings"
+	"sync"
+	"sync/atomic"
 	"time"
 
 	"github.com/blinklabs-io/cdnsd/internal/config"
@@ -26,17 +30,29 @@ import (
 	input_chainsync "github.com/blinklabs-io/adder/input/chainsync"
 	output_embedded "github.com/blinklabs-io/adder/output/embedded"
 	"github.com/blinklabs-io/adder/pipeline"
-	models "github.com/blinklabs-io/cardano-models"
+	"github.com/blinklabs-io/adder/plugin"
 	"github.com/blinklabs-io/gouroboros/cbor"
 	"github.com/blinklabs-io/gouroboros/ledger"
 	ocommon "github.com/blinklabs-io/gouroboros/protocol/common"
 	"github.com/miekg/dns"
+	"golang.org/x/crypto/blake2b"
+	"golang.org/x/sync/errgroup"
 )
 
 const (
 	syncStatusLogInterval = 30 * time.Second
+	// Backoff delays for restarting a failed pipeline
+	pipelineRestartMinDelay = 1 * time.Second
+	pipelineRestartMaxDelay = 5 * time.Minute
+	// Number of consecutive pipeline failures before the indexer is reported as unhealthy
+	pipelineFailureThreshold = 5
+	// Size of a script hash or policy ID (Blake2b-224)
+	scriptHashSize = 28
 )
 
+// CIP-68 asset name label prefix for user NFTs (222)
+var cip68UserTokenPrefix = [4]byte{0x00, 0x0d, 0xe1, 0x40}
+
 var (
 	metricSlot = promauto.NewGauge(prometheus.GaugeOpts{
 		Name: "indexer_slot",
@@ -46,6 +62,23 @@ var (
 		Name: "indexer_tip_slot",
 		Help: "Slot number for upstream chain tip",
 	})
+	metricMaxRecordsPerNameExceeded = promauto.NewCounter(prometheus.CounterOpts{
+		Name: "indexer_max_records_per_name_exceeded_total",
+		Help: "total domain updates exceeding the per-name record limit",
+	})
+	metricErrorsTotal = promauto.NewCounter(prometheus.CounterOpts{
+		Name: "indexer_errors_total",
+		Help: "total indexer pipeline failures",
+	})
+	metricHealthy = promauto.NewGaugeFunc(prometheus.GaugeOpts{
+		Name: "indexer_healthy",
+		Help: "whether the indexer pipeline is running without repeated failures",
+	}, func() float64 {
+		if globalIndexer.Healthy() {
+			return 1
+		}
+		return 0
+	})
 )
 
 type Domain struct {
@@ -54,50 +87,63 @@ type Domain struct {
 }
 
 type Indexer struct {
-	pipeline     *pipeline.Pipeline
-	domains      map[string]Domain
-	tipReached   bool
-	syncLogTimer *time.Timer
-	syncStatus   input_chainsync.ChainSyncStatus
-	watched      []watchedAddr
+	// The running pipeline, if any. The mutex is held while a pipeline is being replaced
+	pipeline      *pipeline.Pipeline
+	pipelineMutex sync.Mutex
+	domains       map[string]Domain
+	// Sync status fields are updated from the pipeline and read by the sync status log timer
+	tipReached      bool
+	syncLogTimer    *time.Timer
+	syncStatus      input_chainsync.ChainSyncStatus
+	syncStatusMutex sync.Mutex
+	// Number of consecutive pipeline failures
+	pipelineFailures atomic.Int64
+	// Watched addresses keyed by address. The map is replaced rather than modified, so a
+	// reference to it can be safely used as a snapshot
+	watched      map[string]watchedAddr
+	watchedMutex sync.RWMutex
+	// Maps the UTxO ref (hash#idx) holding each discovery asset to the discovered address
+	discoveryUtxos map[string]string
 }
 
 type watchedAddr struct {
-	Address   string
-	Tld       string
-	PolicyId  string
-	Discovery bool
+	Address           string
+	Tld               string
+	PolicyId          string
+	AssetNameEncoding string
+	Discovery         bool
+}
+
+// Creates the chainsync input for a pipeline. This can be replaced for testing
+var newPipelineInput = func(opts ...input_chainsync.ChainSyncOptionFunc) plugin.Plugin {
+	return input_chainsync.New(opts...)
 }
 
 // Singleton indexer instance
 var globalIndexer = &Indexer{
-	domains: make(map[string]Domain),
+	domains:        make(map[string]Domain),
+	discoveryUtxos: make(map[string]string),
 }
 
 func (i *Indexer) Start() error {
 	// Build watched addresses from enabled profiles
-	cfg := config.GetConfig()
+	watched := map[string]watchedAddr{}
 	for _, profile := range config.GetProfiles() {
 		if profile.ScriptAddress != "" {
 			// Add a static TLD mapping
-			i.watched = append(
-				i.watched,
-				watchedAddr{
-					Address:  profile.ScriptAddress,
-					Tld:      profile.Tld,
-					PolicyId: profile.PolicyId,
-				},
-			)
+			watched[profile.ScriptAddress] = watchedAddr{
+				Address:           profile.ScriptAddress,
+				Tld:               profile.Tld,
+				PolicyId:          profile.PolicyId,
+				AssetNameEncoding: profile.AssetNameEncoding,
+			}
 		} else if profile.DiscoveryAddress != "" {
 			// Add an auto-discovery address
-			i.watched = append(
-				i.watched,
-				watchedAddr{
-					Address:   profile.DiscoveryAddress,
-					PolicyId:  profile.PolicyId,
-					Discovery: true,
-				},
-			)
+			watched[profile.DiscoveryAddress] = watchedAddr{
+				Address:   profile.DiscoveryAddress,
+				PolicyId:  profile.PolicyId,
+				Discovery: true,
+			}
 		}
 	}
 	// Load discovered TLDs from state
@@ -105,23 +151,45 @@ func (i *Indexer) Start() error {
 	if err != nil {
 		return err
 	}
+	i.watchedMutex.Lock()
 	for _, tmpAddr := range discoveredAddr {
-		i.watched = append(
-			i.watched,
-			watchedAddr{
-				Address:  tmpAddr.Address,
-				PolicyId: tmpAddr.PolicyId,
-				Tld:      tmpAddr.TldName,
-			},
-		)
+		watched[tmpAddr.Address] = watchedAddr{
+			Address:  tmpAddr.Address,
+			PolicyId: tmpAddr.PolicyId,
+			Tld:      tmpAddr.TldName,
+		}
+		if tmpAddr.TxHash != "" {
+			i.discoveryUtxos[utxoRef(tmpAddr.TxHash, tmpAddr.TxOutputIdx)] = tmpAddr.Address
+		}
 	}
+	i.watched = watched
+	i.watchedMutex.Unlock()
+	// Start pipeline, retrying in the background on failure so that DNS can still be
+	// served from already indexed data
+	if err := i.startPipeline(); err != nil {
+		i.pipelineFailed(err)
+	}
+	// Schedule periodic catch-up sync log messages
+	i.scheduleSyncStatusLog()
+	return nil
+}
+
+// startPipeline creates and starts a new pipeline, resuming from the stored chainsync cursor. Any
+// running pipeline is stopped first
+func (i *Indexer) startPipeline() error {
+	cfg := config.GetConfig()
+	i.pipelineMutex.Lock()
+	defer i.pipelineMutex.Unlock()
+	i.stopPipeline()
 	// Create pipeline
-	i.pipeline = pipeline.New()
+	p := pipeline.New()
 	// Configure pipeline input
 	inputOpts := []input_chainsync.ChainSyncOptionFunc{
 		input_chainsync.WithStatusUpdateFunc(
 			func(status input_chainsync.ChainSyncStatus) {
-				i.syncStatus = status
+				i.updateSyncStatus(status)
+				// Reset the failure count once the pipeline is making progress
+				i.pipelineFailures.Store(0)
 				metricSlot.Set(float64(status.SlotNumber))
 				metricTipSlot.Set(float64(status.TipSlotNumber))
 				if err := state.GetState().UpdateCursor(status.SlotNumber, status.BlockHash); err != nil {
@@ -129,12 +197,25 @@ func (i *Indexer) Start() error {
 						fmt.Sprintf("failed to update cursor: %s", err),
 					)
 				}
-				if !i.tipReached && status.TipReached {
-					if i.syncLogTimer != nil {
-						i.syncLogTimer.Stop()
+				// Apply queued domain updates that now have enough confirmations
+				if minConfirmations := cfg.Indexer.MinConfirmations; minConfirmations > 0 &&
+					status.BlockNumber >= minConfirmations {
+					applied, err := state.GetState().ApplyPendingDomainUpdates(
+						status.BlockNumber - minConfirmations,
+					)
+					if err != nil {
+						slog.Error(
+							fmt.Sprintf("failed to apply confirmed domain updates: %s", err),
+						)
+					}
+					if applied > 0 {
+						slog.Info(
+							fmt.Sprintf(
+								"applied %d confirmed domain update(s)",
+								applied,
+							),
+						)
 					}
-					i.tipReached = true
-					slog.Info("caught up to chain tip")
 				}
 			},
 		),
@@ -200,97 +281,267 @@ func (i *Indexer) Start() error {
 			),
 		)
 	}
-	input := input_chainsync.New(
+	input := newPipelineInput(
 		inputOpts...,
 	)
-	i.pipeline.AddInput(input)
+	p.AddInput(input)
 	// Configure pipeline filters
-	// We only care about transaction events
+	// We only care about transaction and rollback events
 	filterEvent := filter_event.New(
-		filter_event.WithTypes([]string{"chainsync.transaction"}),
+		filter_event.WithTypes(
+			[]string{
+				"chainsync.transaction",
+				"chainsync.rollback",
+			},
+		),
 	)
-	i.pipeline.AddFilter(filterEvent)
+	p.AddFilter(filterEvent)
 	// Configure pipeline output
 	output := output_embedded.New(
 		output_embedded.WithCallbackFunc(i.handleEvent),
 	)
-	i.pipeline.AddOutput(output)
+	p.AddOutput(output)
 	// Start pipeline
-	if err := i.pipeline.Start(); err != nil {
-		slog.Error(
-			fmt.Sprintf("failed to start pipeline: %s\n", err),
-		)
-		os.Exit(1)
+	if err := p.Start(); err != nil {
+		return fmt.Errorf("failed to start pipeline: %w", err)
 	}
+	i.pipeline = p
 	// Start error handler
-	go func() {
-		err, ok := <-i.pipeline.ErrorChan()
-		if ok {
-			slog.Error(
-				fmt.Sprintf("pipeline failed: %s\n", err),
-			)
-			os.Exit(1)
-		}
-	}()
-	// Schedule periodic catch-up sync log messages
-	i.scheduleSyncStatusLog()
+	go i.handlePipelineErrors(p)
 	return nil
 }
 
+// stopPipeline stops the running pipeline, if any. This must be called with the pipeline mutex held
+func (i *Indexer) stopPipeline() {
+	if i.pipeline == nil {
+		return
+	}
+	if err := i.pipeline.Stop(); err != nil {
+		slog.Error(
+			fmt.Sprintf("failed to stop pipeline: %s", err),
+		)
+	}
+	i.pipeline = nil
+}
+
+// handlePipelineErrors waits for an error from the specified pipeline and restarts it. The error
+// channel is closed without an error when the pipeline is stopped
+func (i *Indexer) handlePipelineErrors(p *pipeline.Pipeline) {
+	err, ok := <-p.ErrorChan()
+	if !ok {
+		return
+	}
+	i.pipelineMutex.Lock()
+	// Ignore errors from a pipeline that has already been replaced
+	if i.pipeline != p {
+		i.pipelineMutex.Unlock()
+		return
+	}
+	// The pipeline stops itself after reporting an error, so it must not be stopped again
+	i.pipeline = nil
+	i.pipelineMutex.Unlock()
+	i.pipelineFailed(fmt.Errorf("pipeline failed: %w", err))
+}
+
+// pipelineFailed records a pipeline failure and schedules a restart of the pipeline with an
+// exponential backoff
+func (i *Indexer) pipelineFailed(err error) {
+	metricErrorsTotal.Inc()
+	failures := i.pipelineFailures.Add(1)
+	delay := pipelineRestartMaxDelay
+	if failures <= 16 {
+		delay = min(pipelineRestartMinDelay<<(failures-1), pipelineRestartMaxDelay)
+	}
+	slog.Error(
+		fmt.Sprintf(
+			"%s, restarting in %s",
+			err,
+			delay,
+		),
+	)
+	if failures == pipelineFailureThreshold {
+		slog.Error(
+			fmt.Sprintf(
+				"indexer is unhealthy after %d consecutive pipeline failures",
+				failures,
+			),
+		)
+	}
+	time.AfterFunc(
+		delay,
+		func() {
+			if err := i.startPipeline(); err != nil {
+				i.pipelineFailed(err)
+			}
+		},
+	)
+}
+
+// Healthy returns whether the indexer pipeline is running without repeated failures
+func (i *Indexer) Healthy() bool {
+	return i.pipelineFailures.Load() < pipelineFailureThreshold
+}
+
 func (i *Indexer) handleEvent(evt event.Event) error {
+	if rollbackEvt, ok := evt.Payload.(input_chainsync.RollbackEvent); ok {
+		return i.handleRollback(rollbackEvt)
+	}
 	eventTx := evt.Payload.(input_chainsync.TransactionEvent)
 	eventCtx := evt.Context.(input_chainsync.TransactionContext)
-	for _, txOutput := range eventTx.Outputs {
-		// Full address
-		outAddr := txOutput.Address()
-		// Only the payment portion of the address
-		// This is useful for comparing to generated script addresses
-		outAddrPayment := outAddr.PaymentAddress()
-		if outAddrPayment == nil {
+	// Check for spent UTxOs holding discovery assets
+	var spentDiscovery []string
+	i.watchedMutex.Lock()
+	for _, txInput := range eventTx.Inputs {
+		tmpRef := utxoRef(txInput.Id().String(), txInput.Index())
+		if tmpAddr, ok := i.discoveryUtxos[tmpRef]; ok {
+			spentDiscovery = append(spentDiscovery, tmpAddr)
+			delete(i.discoveryUtxos, tmpRef)
+		}
+	}
+	i.watchedMutex.Unlock()
+	// Take a snapshot of the watched addresses, since the discovery handler may modify them
+	watched := i.watchedAddrs()
+	// Decode DNS outputs, concurrently if configured. The results are applied afterward in output
+	// order, so that the outcome doesn't depend on scheduling
+	updates := make([]*domainUpdate, len(eventTx.Outputs))
+	outputWorkers := config.GetConfig().Indexer.OutputWorkers
+	if outputWorkers > 1 && len(eventTx.Outputs) > 1 {
+		// Process outputs concurrently with a bounded number of workers
+		var eg errgroup.Group
+		eg.SetLimit(outputWorkers)
+		for idx, txOutput := range eventTx.Outputs {
+			eg.Go(func() error {
+				update, err := i.decodeEventOutput(eventCtx, watched, txOutput)
+				updates[idx] = update
+				return err
+			})
+		}
+		if err := eg.Wait(); err != nil {
+			return err
+		}
+	} else {
+		for idx, txOutput := range eventTx.Outputs {
+			update, err := i.decodeEventOutput(eventCtx, watched, txOutput)
+			if err != nil {
+				return err
+			}
+			updates[idx] = update
+		}
+	}
+	for idx, txOutput := range eventTx.Outputs {
+		// Discovery outputs modify the watched addresses, so they are always handled serially
+		if tmpAddr, ok := matchWatchedAddr(watched, txOutput); ok && tmpAddr.Discovery {
+			if err := i.handleEventOutputDiscovery(eventCtx, tmpAddr.PolicyId, uint32(idx), txOutput); err != nil {
+				return err
+			}
 			continue
 		}
-		for _, watchedAddr := range i.watched {
-			if watchedAddr.Discovery {
-				if outAddr.String() == watchedAddr.Address ||
-					outAddrPayment.String() == watchedAddr.Address {
-					if err := i.handleEventOutputDiscovery(eventCtx, watchedAddr.PolicyId, txOutput); err != nil {
-						return err
-					}
-					break
-				}
-			} else {
-				if outAddr.String() == watchedAddr.Address || outAddrPayment.String() == watchedAddr.Address {
-					if err := i.handleEventOutputDns(eventCtx, watchedAddr.Tld, watchedAddr.PolicyId, txOutput); err != nil {
-						return err
-					}
-					break
-				}
+		if updates[idx] == nil {
+			continue
+		}
+		if err := applyDomainUpdate(eventCtx, updates[idx]); err != nil {
+			return err
+		}
+	}
+	// Retire any discovered TLDs whose discovery asset was spent and not re-created
+	for _, tmpAddr := range spentDiscovery {
+		stillPresent := false
+		i.watchedMutex.RLock()
+		for _, utxoAddr := range i.discoveryUtxos {
+			if utxoAddr == tmpAddr {
+				stillPresent = true
+				break
 			}
 		}
+		i.watchedMutex.RUnlock()
+		if stillPresent {
+			continue
+		}
+		if err := i.removeDiscoveredAddress(tmpAddr); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// handleRollback discards any queued domain updates from the rolled back blocks
+func (i *Indexer) handleRollback(evt input_chainsync.RollbackEvent) error {
+	discarded, err := state.GetState().DiscardPendingDomainUpdates(evt.SlotNumber)
+	if err != nil {
+		return err
+	}
+	if discarded > 0 {
+		slog.Warn(
+			fmt.Sprintf(
+				"discarded %d unconfirmed domain update(s) after rollback to slot %d",
+				discarded,
+				evt.SlotNumber,
+			),
+		)
 	}
 	return nil
 }
 
-func (i *Indexer) handleEventOutputDns(
+// domainUpdate is the decoded set of records for a domain from a transaction output
+type domainUpdate struct {
+	DomainName string
+	Records    []state.DomainRecord
+}
+
+// matchWatchedAddr returns the watched address for a transaction output, matching on either the full
+// address or only the payment portion
+func matchWatchedAddr(
+	watched map[string]watchedAddr,
+	txOutput ledger.TransactionOutput,
+) (watchedAddr, bool) {
+	// Full address
+	outAddr := txOutput.Address()
+	// Only the payment portion of the address
+	// This is useful for comparing to generated script addresses
+	outAddrPayment := outAddr.PaymentAddress()
+	if outAddrPayment == nil {
+		return watchedAddr{}, false
+	}
+	if tmpAddr, ok := watched[outAddr.String()]; ok {
+		return tmpAddr, true
+	}
+	tmpAddr, ok := watched[outAddrPayment.String()]
+	return tmpAddr, ok
+}
+
+// decodeEventOutput decodes the domain update from a transaction output to a watched TLD address. It
+// doesn't modify any state, so it's safe to call concurrently
+func (i *Indexer) decodeEventOutput(
+	eventCtx input_chainsync.TransactionContext,
+	watched map[string]watchedAddr,
+	txOutput ledger.TransactionOutput,
+) (*domainUpdate, error) {
+	watchedAddr, ok := matchWatchedAddr(watched, txOutput)
+	if !ok || watchedAddr.Discovery {
+		return nil, nil
+	}
+	return decodeEventOutputDns(eventCtx, watchedAddr.Tld, watchedAddr.PolicyId, watchedAddr.AssetNameEncoding, txOutput)
+}
+
+func decodeEventOutputDns(
 	eventCtx input_chainsync.TransactionContext,
 	tldName string,
 	policyId string,
+	assetNameEncoding string,
 	txOutput ledger.TransactionOutput,
-) error {
+) (*domainUpdate, error) {
 	cfg := config.GetConfig()
 	datum := txOutput.Datum()
 	if datum != nil {
-		var dnsDomain models.CardanoDnsDomain
+		var dnsDomain CardanoDnsDomainDatum
 		if _, err := cbor.Decode(datum.Cbor(), &dnsDomain); err != nil {
-			slog.Warn(
-				fmt.Sprintf(
-					"error decoding TX (%s) output datum as CardanoDnsDomain: %s",
-					eventCtx.TransactionHash,
-					err,
-				),
+			logging.Warnf(
+				"error decoding TX (%s) output datum as CardanoDnsDomain: %s",
+				eventCtx.TransactionHash,
+				err,
 			)
 			// Stop processing TX output if we can't parse the datum
-			return nil
+			return nil, nil
 		}
 		origin := string(dnsDomain.Origin)
 		// Convert origin to canonical form for consistency
@@ -307,19 +558,21 @@ func (i *Indexer) handleEventOutputDns(
 		if cfg.Indexer.Verify {
 			// Look for asset matching domain origin and TLD policy ID
 			if txOutput.Assets() == nil {
-				slog.Warn(
-					fmt.Sprintf(
-						"ignoring datum for domain %q with no matching asset",
-						domainName,
-					),
+				logging.Warnf(
+					"ignoring datum for domain %q with no matching asset",
+					domainName,
 				)
-				return nil
+				return nil, nil
+			}
+			expectedAssetName, err := originAssetName(origin, assetNameEncoding)
+			if err != nil {
+				return nil, err
 			}
 			foundAsset := false
 			for _, tmpPolicyId := range txOutput.Assets().Policies() {
 				for _, assetName := range txOutput.Assets().Assets(tmpPolicyId) {
 					if tmpPolicyId.String() == policyId {
-						if string(assetName) == string(origin) {
+						if bytes.Equal(assetName, expectedAssetName) {
 							foundAsset = true
 							break
 						}
@@ -330,65 +583,269 @@ func (i *Indexer) handleEventOutputDns(
 				}
 			}
 			if !foundAsset {
-				slog.Warn(
-					fmt.Sprintf(
-						"ignoring datum for domain %q with no matching asset",
-						domainName,
-					),
+				logging.Warnf(
+					"ignoring datum for domain %q with no matching asset",
+					domainName,
 				)
-				return nil
+				return nil, nil
 			}
 			// Make sure all records are for specified origin domain
 			badRecordName := false
 			for _, record := range dnsDomain.Records {
-				recordName := dns.CanonicalName(
-					string(record.Lhs),
-				)
-				if !strings.HasSuffix(recordName, domainName) {
-					slog.Warn(
-						fmt.Sprintf(
-							"ignoring datum with record %q outside of origin domain (%s)",
-							recordName,
-							domainName,
-						),
+				recordName := qualifyRecordName(string(record.Lhs), domainName)
+				if !dns.IsSubDomain(domainName, recordName) {
+					logging.Warnf(
+						"ignoring datum with record %q outside of origin domain (%s)",
+						recordName,
+						domainName,
 					)
 					badRecordName = true
 					break
 				}
 			}
 			if badRecordName {
-				return nil
+				return nil, nil
 			}
 		}
 		// Convert domain records into our storage format
 		tmpRecords := []state.DomainRecord{}
 		for _, record := range dnsDomain.Records {
+			recordName := qualifyRecordName(string(record.Lhs), domainName)
+			// Always drop records outside of the origin domain, regardless of verify setting
+			if !dns.IsSubDomain(domainName, recordName) {
+				logging.Warnf(
+					"ignoring record %q outside of origin domain (%s)",
+					recordName,
+					domainName,
+				)
+				continue
+			}
+			// Only accept allowed record types
+			if !slices.ContainsFunc(
+				cfg.Indexer.RecordTypes,
+				func(recordType string) bool {
+					return strings.EqualFold(recordType, string(record.Type))
+				},
+			) {
+				logging.Warnf(
+					"ignoring record %q with unsupported type %q",
+					recordName,
+					record.Type,
+				)
+				continue
+			}
+			switch strings.ToUpper(string(record.Type)) {
+			case config.RecordTypeAlias:
+				// ALIAS records must point to a domain name
+				if _, ok := dns.IsDomainName(string(record.Rhs)); !ok {
+					logging.Warnf(
+						"ignoring ALIAS record %q with invalid target %q",
+						recordName,
+						record.Rhs,
+					)
+					continue
+				}
+			case "HTTPS", "SVCB":
+				if err := validateSvcbRecord(recordName, string(record.Type), string(record.Rhs)); err != nil {
+					logging.Warnf(
+						"ignoring invalid %s record %q: %s",
+						strings.ToUpper(string(record.Type)),
+						recordName,
+						err,
+					)
+					continue
+				}
+			}
 			tmpRecord := state.DomainRecord{
-				Lhs:  string(record.Lhs),
+				Lhs:  recordName,
 				Type: string(record.Type),
 				Rhs:  string(record.Rhs),
 			}
 			if record.Ttl.HasValue() {
 				tmpRecord.Ttl = int(record.Ttl.Value)
 			}
+			if record.Class.HasValue() {
+				recordClass := strings.ToUpper(string(record.Class.Value))
+				if _, ok := dns.StringToClass[recordClass]; ok {
+					tmpRecord.Class = recordClass
+				} else {
+					logging.Warnf(
+						"ignoring unknown class %q for record %q",
+						recordClass,
+						recordName,
+					)
+				}
+			}
+			if record.Comment.HasValue() {
+				tmpRecord.Comment = string(record.Comment.Value)
+			}
 			tmpRecords = append(tmpRecords, tmpRecord)
 		}
-		if err := state.GetState().UpdateDomain(domainName, tmpRecords); err != nil {
+		tmpRecords, ok := limitRecordsPerName(domainName, tmpRecords)
+		if !ok {
+			return nil, nil
+		}
+		return &domainUpdate{
+			DomainName: domainName,
+			Records:    tmpRecords,
+		}, nil
+	}
+	return nil, nil
+}
+
+// applyDomainUpdate stores a decoded domain update, or queues it until it has enough confirmations if
+// configured
+func applyDomainUpdate(
+	eventCtx input_chainsync.TransactionContext,
+	update *domainUpdate,
+) error {
+	cfg := config.GetConfig()
+	if cfg.Indexer.MinConfirmations > 0 {
+		err := state.GetState().QueueDomainUpdate(
+			update.DomainName,
+			update.Records,
+			eventCtx.SlotNumber,
+			eventCtx.BlockNumber,
+		)
+		if err != nil {
 			return err
 		}
 		slog.Info(
 			fmt.Sprintf(
-				"found updated registration for domain: %s",
-				domainName,
+				"found updated registration for domain: %s, waiting for %d confirmations",
+				update.DomainName,
+				cfg.Indexer.MinConfirmations,
 			),
 		)
+		return nil
+	}
+	if err := state.GetState().UpdateDomain(update.DomainName, update.Records); err != nil {
+		return err
 	}
+	slog.Info(
+		fmt.Sprintf(
+			"found updated registration for domain: %s",
+			update.DomainName,
+		),
+	)
 	return nil
 }
 
+// qualifyRecordName returns the canonical name for a record LHS. An empty or "@" LHS refers to the
+// origin domain itself, and any other LHS is an absolute name, even without a trailing dot
+func qualifyRecordName(lhs string, domainName string) string {
+	switch lhs {
+	case "", "@":
+		return domainName
+	}
+	return dns.CanonicalName(lhs)
+}
+
+// limitRecordsPerName applies the configured per-name record limit to the records for a domain. It
+// returns false if the whole update should be rejected
+func limitRecordsPerName(
+	domainName string,
+	records []state.DomainRecord,
+) ([]state.DomainRecord, bool) {
+	cfg := config.GetConfig()
+	if cfg.Dns.MaxRecordsPerName == 0 {
+		return records, true
+	}
+	ret := make([]state.DomainRecord, 0, len(records))
+	nameCounts := make(map[string]int)
+	exceeded := false
+	for _, record := range records {
+		recordName := dns.CanonicalName(record.Lhs)
+		if nameCounts[recordName] >= cfg.Dns.MaxRecordsPerName {
+			if !exceeded {
+				logging.Warnf(
+					"domain %s exceeds limit of %d records for name %s",
+					domainName,
+					cfg.Dns.MaxRecordsPerName,
+					recordName,
+				)
+			}
+			exceeded = true
+			continue
+		}
+		nameCounts[recordName]++
+		ret = append(ret, record)
+	}
+	if !exceeded {
+		return records, true
+	}
+	metricMaxRecordsPerNameExceeded.Inc()
+	if cfg.Dns.MaxRecordsAction == config.MaxRecordsActionReject {
+		logging.Warnf(
+			"ignoring update for domain %s exceeding per-name record limit",
+			domainName,
+		)
+		return nil, false
+	}
+	return ret, true
+}
+
+// validateSvcbRecord checks that an SVCB or HTTPS record parses and that all keys listed in its
+// mandatory parameter are present (RFC 9460, section 8)
+func validateSvcbRecord(recordName string, recordType string, rhs string) error {
+	tmpRR, err := dns.NewRR(
+		fmt.Sprintf("%s IN %s %s", dns.Fqdn(recordName), recordType, rhs),
+	)
+	if err != nil {
+		return err
+	}
+	var svcb *dns.SVCB
+	switch v := tmpRR.(type) {
+	case *dns.SVCB:
+		svcb = v
+	case *dns.HTTPS:
+		svcb = &v.SVCB
+	default:
+		return fmt.Errorf("unexpected record type: %s", dns.TypeToString[tmpRR.Header().Rrtype])
+	}
+	keys := map[dns.SVCBKey]bool{}
+	var mandatory []dns.SVCBKey
+	for _, kv := range svcb.Value {
+		if keys[kv.Key()] {
+			return fmt.Errorf("duplicate key: %s", kv.Key())
+		}
+		keys[kv.Key()] = true
+		if v, ok := kv.(*dns.SVCBMandatory); ok {
+			mandatory = v.Code
+		}
+	}
+	for _, key := range mandatory {
+		if key == dns.SVCB_MANDATORY {
+			return fmt.Errorf("mandatory key must not list itself")
+		}
+		if !keys[key] {
+			return fmt.Errorf("mandatory key %s is missing", key)
+		}
+	}
+	return nil
+}
+
+// originAssetName returns the expected verification asset name for a domain origin using the specified encoding
+func originAssetName(origin string, encoding string) ([]byte, error) {
+	switch encoding {
+	case "", config.AssetNameEncodingRaw:
+		return []byte(origin), nil
+	case config.AssetNameEncodingHex:
+		return []byte(hex.EncodeToString([]byte(origin))), nil
+	case config.AssetNameEncodingBlake2b:
+		tmpHash := blake2b.Sum256([]byte(origin))
+		return tmpHash[:], nil
+	case config.AssetNameEncodingCip68:
+		return append(cip68UserTokenPrefix[:], []byte(origin)...), nil
+	default:
+		return nil, fmt.Errorf("unknown asset name encoding: %s", encoding)
+	}
+}
+
 func (i *Indexer) handleEventOutputDiscovery(
 	eventCtx input_chainsync.TransactionContext,
 	policyId string,
+	txOutputIdx uint32,
 	txOutput ledger.TransactionOutput,
 ) error {
 	cfg := config.GetConfig()
@@ -409,11 +866,9 @@ func (i *Indexer) handleEventOutputDiscovery(
 		// Look for asset matching policy ID
 		var assetName []byte
 		if txOutput.Assets() == nil {
-			slog.Warn(
-				fmt.Sprintf(
-					"ignoring datum for DNS script for domain %q with no matching asset",
-					scriptRef.TldName,
-				),
+			logging.Warnf(
+				"ignoring datum for DNS script for domain %q with no matching asset",
+				scriptRef.TldName,
 			)
 			return nil
 		}
@@ -426,11 +881,39 @@ func (i *Indexer) handleEventOutputDiscovery(
 			}
 		}
 		if assetName == nil {
-			slog.Warn(
-				fmt.Sprintf(
-					"ignoring datum for DNS script for domain %q with no matching asset",
-					scriptRef.TldName,
-				),
+			logging.Warnf(
+				"ignoring datum for DNS script for domain %q with no matching asset",
+				scriptRef.TldName,
+			)
+			return nil
+		}
+		// Validate discovery data before watching the resulting address
+		tldName := strings.TrimPrefix(
+			string(scriptRef.TldName),
+			`.`,
+		)
+		if _, ok := dns.IsDomainName(tldName); !ok ||
+			tldName == "" ||
+			dns.CountLabel(tldName) != 1 {
+			logging.Warnf(
+				"ignoring datum for DNS script with invalid TLD %q",
+				scriptRef.TldName,
+			)
+			return nil
+		}
+		if len(scriptRef.SymbolDrat) != scriptHashSize {
+			logging.Warnf(
+				"ignoring datum for DNS script for domain %q with invalid policy ID length: %d",
+				scriptRef.TldName,
+				len(scriptRef.SymbolDrat),
+			)
+			return nil
+		}
+		if len(assetName) != scriptHashSize {
+			logging.Warnf(
+				"ignoring datum for DNS script for domain %q with invalid script hash length: %d",
+				scriptRef.TldName,
+				len(assetName),
 			)
 			return nil
 		}
@@ -446,28 +929,42 @@ func (i *Indexer) handleEventOutputDiscovery(
 			nil,
 		)
 		if err != nil {
-			return err
+			logging.Warnf(
+				"ignoring datum for DNS script for domain %q with invalid script address: %s",
+				scriptRef.TldName,
+				err,
+			)
+			return nil
 		}
-		i.watched = append(
-			i.watched,
-			watchedAddr{
-				Tld: strings.TrimPrefix(
-					string(scriptRef.TldName),
-					`.`,
-				),
-				PolicyId: hex.EncodeToString(scriptRef.SymbolDrat),
-				Address:  scriptAddr.String(),
-			},
-		)
+		// Make sure the generated address round-trips
+		if tmpAddr, err := ledger.NewAddress(scriptAddr.String()); err != nil ||
+			!bytes.Equal(tmpAddr.Bytes(), scriptAddr.Bytes()) {
+			logging.Warnf(
+				"ignoring datum for DNS script for domain %q with malformed script address %s",
+				scriptRef.TldName,
+				scriptAddr.String(),
+			)
+			return nil
+		}
+		i.watchedMutex.Lock()
+		watched := maps.Clone(i.watched)
+		watched[scriptAddr.String()] = watchedAddr{
+			Tld:      tldName,
+			PolicyId: hex.EncodeToString(scriptRef.SymbolDrat),
+			Address:  scriptAddr.String(),
+		}
+		i.watched = watched
+		i.discoveryUtxos[utxoRef(eventCtx.TransactionHash, txOutputIdx)] = scriptAddr.String()
+		i.watchedMutex.Unlock()
 		// Add to state
 		err = state.GetState().AddDiscoveredAddress(
 			state.DiscoveredAddress{
-				Address:  scriptAddr.String(),
-				PolicyId: hex.EncodeToString(scriptRef.SymbolDrat),
-				TldName: strings.TrimPrefix(
-					string(scriptRef.TldName),
-					`.`,
-				),
+				Address:      scriptAddr.String(),
+				PolicyId:     hex.EncodeToString(scriptRef.SymbolDrat),
+				TldName:      tldName,
+				TxHash:       eventCtx.TransactionHash,
+				TxOutputIdx:  txOutputIdx,
+				LastSeenSlot: eventCtx.SlotNumber,
 			},
 		)
 		if err != nil {
@@ -483,22 +980,119 @@ func (i *Indexer) handleEventOutputDiscovery(
 	return nil
 }
 
+// removeDiscoveredAddress stops watching a discovered TLD address and removes it from state
+func (i *Indexer) removeDiscoveredAddress(address string) error {
+	var tldName string
+	i.watchedMutex.Lock()
+	if tmpAddr, ok := i.watched[address]; ok && !tmpAddr.Discovery {
+		tldName = tmpAddr.Tld
+		watched := maps.Clone(i.watched)
+		delete(watched, address)
+		i.watched = watched
+	}
+	i.watchedMutex.Unlock()
+	if err := state.GetState().RemoveDiscoveredAddress(address); err != nil {
+		return err
+	}
+	slog.Info(
+		fmt.Sprintf(
+			"removed retired TLD: %s",
+			tldName,
+		),
+	)
+	return nil
+}
+
+// updateSyncStatus records the latest sync status from the pipeline and stops the catch-up sync
+// log messages once the chain tip has been reached
+func (i *Indexer) updateSyncStatus(status input_chainsync.ChainSyncStatus) {
+	i.syncStatusMutex.Lock()
+	defer i.syncStatusMutex.Unlock()
+	i.syncStatus = status
+	if !i.tipReached && status.TipReached {
+		if i.syncLogTimer != nil {
+			i.syncLogTimer.Stop()
+		}
+		i.tipReached = true
+		slog.Info("caught up to chain tip")
+	}
+}
+
 func (i *Indexer) scheduleSyncStatusLog() {
+	i.syncStatusMutex.Lock()
+	defer i.syncStatusMutex.Unlock()
+	if i.tipReached {
+		return
+	}
 	i.syncLogTimer = time.AfterFunc(syncStatusLogInterval, i.syncStatusLog)
 }
 
 func (i *Indexer) syncStatusLog() {
+	i.syncStatusMutex.Lock()
+	syncStatus := i.syncStatus
+	tipReached := i.tipReached
+	i.syncStatusMutex.Unlock()
+	if tipReached {
+		return
+	}
 	slog.Info(
 		fmt.Sprintf(
 			"catch-up sync in progress: at %d.%s (current tip slot is %d)",
-			i.syncStatus.SlotNumber,
-			i.syncStatus.BlockHash,
-			i.syncStatus.TipSlotNumber,
+			syncStatus.SlotNumber,
+			syncStatus.BlockHash,
+			syncStatus.TipSlotNumber,
 		),
 	)
 	i.scheduleSyncStatusLog()
 }
 
+// watchedAddrs returns a snapshot of the current watched addresses, which must not be modified
+func (i *Indexer) watchedAddrs() map[string]watchedAddr {
+	i.watchedMutex.RLock()
+	defer i.watchedMutex.RUnlock()
+	return i.watched
+}
+
+// WatchedTld describes an address being watched by the indexer
+type WatchedTld struct {
+	Tld      string `json:"tld"`
+	PolicyId string `json:"policyId"`
+	Address  string `json:"address"`
+	// Whether this is an auto-discovery address, rather than the script address for a TLD
+	Discovery bool `json:"discovery"`
+}
+
+// WatchedTlds returns the addresses currently watched by the indexer, sorted by TLD and address
+func (i *Indexer) WatchedTlds() []WatchedTld {
+	watched := i.watchedAddrs()
+	ret := make([]WatchedTld, 0, len(watched))
+	for _, tmpAddr := range watched {
+		ret = append(
+			ret,
+			WatchedTld{
+				Tld:       tmpAddr.Tld,
+				PolicyId:  tmpAddr.PolicyId,
+				Address:   tmpAddr.Address,
+				Discovery: tmpAddr.Discovery,
+			},
+		)
+	}
+	slices.SortFunc(
+		ret,
+		func(a, b WatchedTld) int {
+			if c := strings.Compare(a.Tld, b.Tld); c != 0 {
+				return c
+			}
+			return strings.Compare(a.Address, b.Address)
+		},
+	)
+	return ret
+}
+
+func utxoRef(txHash string, txOutputIdx uint32) string {
+	return fmt.Sprintf("%s#%d", txHash, txOutputIdx)
+}
+
 func (i *Indexer) LookupDomain(name string) *Domain {
 	if domain, ok := i.domains[name]; ok {
 		return &domain
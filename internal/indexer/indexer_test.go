@@ -0,0 +1,492 @@
+// Copyright 2024 Blink Labs Software
+//
+// Use of this source code is governed by an MIT-style
+// license that can be found in the LICENSE file or at
+// https://opensource.org/licenses/MIT.
+
+package indexer
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"os"
+	"slices"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/blinklabs-io/cdnsd/internal/config"
+	"github.com/blinklabs-io/cdnsd/internal/state"
+
+	"github.com/blinklabs-io/adder/event"
+	input_chainsync "github.com/blinklabs-io/adder/input/chainsync"
+	"github.com/blinklabs-io/adder/plugin"
+	"github.com/blinklabs-io/gouroboros/cbor"
+	"github.com/blinklabs-io/gouroboros/ledger"
+	lcommon "github.com/blinklabs-io/gouroboros/ledger/common"
+	"github.com/miekg/dns"
+)
+
+const testTld = "test"
+
+// testTxOutput is a minimal transaction output with an address, assets, and an inline datum
+type testTxOutput struct {
+	ledger.TransactionOutput
+	address ledger.Address
+	assets  *lcommon.MultiAsset[ledger.MultiAssetTypeOutput]
+	datum   *cbor.LazyValue
+}
+
+func (o testTxOutput) Address() ledger.Address {
+	return o.address
+}
+
+func (o testTxOutput) Assets() *lcommon.MultiAsset[ledger.MultiAssetTypeOutput] {
+	return o.assets
+}
+
+func (o testTxOutput) Datum() *cbor.LazyValue {
+	return o.datum
+}
+
+// testHash returns a deterministic script hash or policy ID for the specified seed
+func testHash(seed string) []byte {
+	return lcommon.Blake2b224Hash([]byte(seed)).Bytes()
+}
+
+// testScriptAddress returns a mainnet script address for the specified script hash
+func testScriptAddress(t testing.TB, scriptHash []byte) ledger.Address {
+	t.Helper()
+	addr, err := ledger.NewAddressFromParts(
+		ledger.AddressTypeScriptNone,
+		lcommon.AddressNetworkMainnet,
+		scriptHash,
+		nil,
+	)
+	if err != nil {
+		t.Fatalf("failed to build script address: %s", err)
+	}
+	return addr
+}
+
+// testOutput builds a transaction output to the specified address holding one of the specified asset, with
+// the specified datum
+func testOutput(
+	t testing.TB,
+	address ledger.Address,
+	policyId []byte,
+	assetName []byte,
+	datum any,
+) ledger.TransactionOutput {
+	t.Helper()
+	datumCbor, err := cbor.Encode(datum)
+	if err != nil {
+		t.Fatalf("failed to encode datum: %s", err)
+	}
+	var lazyDatum cbor.LazyValue
+	if err := lazyDatum.UnmarshalCBOR(datumCbor); err != nil {
+		t.Fatalf("failed to decode datum: %s", err)
+	}
+	assets := lcommon.NewMultiAsset(
+		map[ledger.Blake2b224]map[cbor.ByteString]ledger.MultiAssetTypeOutput{
+			ledger.NewBlake2b224(policyId): {
+				cbor.NewByteString(assetName): 1,
+			},
+		},
+	)
+	return testTxOutput{
+		address: address,
+		assets:  &assets,
+		datum:   &lazyDatum,
+	}
+}
+
+// testDomainDatum returns a CardanoDnsDomain datum for the specified origin with an A record for each
+// of the specified record names
+func testDomainDatum(origin string, recordNames ...string) any {
+	records := []any{}
+	for idx, recordName := range recordNames {
+		records = append(
+			records,
+			cbor.NewConstructor(
+				1,
+				[]any{
+					[]byte(recordName),
+					// Nothing
+					cbor.NewConstructor(1, []any{}),
+					[]byte("A"),
+					[]byte(fmt.Sprintf("192.0.2.%d", idx%250+1)),
+				},
+			),
+		)
+	}
+	return cbor.NewConstructor(
+		1,
+		[]any{
+			[]byte(origin),
+			records,
+		},
+	)
+}
+
+// testDiscoveryDatum returns a DNSReferenceRefScriptDatum for the specified TLD and policy ID
+func testDiscoveryDatum(tldName string, policyId []byte) any {
+	return cbor.NewConstructor(
+		3,
+		[]any{
+			cbor.NewConstructor(
+				1,
+				[]any{
+					[]byte(tldName),
+					policyId,
+					[]byte{},
+				},
+			),
+		},
+	)
+}
+
+// testEvent builds a transaction event with the specified outputs
+func testEvent(txHash string, outputs ...ledger.TransactionOutput) event.Event {
+	return event.Event{
+		Context: input_chainsync.TransactionContext{
+			TransactionHash: txHash,
+		},
+		Payload: input_chainsync.TransactionEvent{
+			Outputs: outputs,
+		},
+	}
+}
+
+// testInput is a pipeline input that produces no events and tracks the number of running inputs
+type testInput struct {
+	errorChan  chan error
+	outputChan chan event.Event
+	running    *atomic.Int64
+}
+
+func (in *testInput) Start() error {
+	in.running.Add(1)
+	return nil
+}
+
+func (in *testInput) Stop() error {
+	in.running.Add(-1)
+	return nil
+}
+
+func (in *testInput) ErrorChan() chan error {
+	return in.errorChan
+}
+
+func (in *testInput) InputChan() chan<- event.Event {
+	return nil
+}
+
+func (in *testInput) OutputChan() <-chan event.Event {
+	return in.outputChan
+}
+
+// setupTestIndexer loads an in-memory state and returns an indexer watching a single static TLD
+func setupTestIndexer(t testing.TB) (*Indexer, ledger.Address, []byte) {
+	t.Helper()
+	cfg := config.GetConfig()
+	cfg.Indexer.Network = "mainnet"
+	if err := state.GetState().LoadInMemory(); err != nil {
+		t.Fatalf("failed to load state: %s", err)
+	}
+	t.Cleanup(func() {
+		_ = state.GetState().Close()
+	})
+	tldAddr := testScriptAddress(t, testHash("tld-script"))
+	tldPolicyId := testHash("tld-policy")
+	i := &Indexer{
+		domains:        make(map[string]Domain),
+		discoveryUtxos: make(map[string]string),
+		watched: map[string]watchedAddr{
+			tldAddr.String(): {
+				Address:  tldAddr.String(),
+				Tld:      testTld,
+				PolicyId: fmt.Sprintf("%x", tldPolicyId),
+			},
+		},
+	}
+	return i, tldAddr, tldPolicyId
+}
+
+// BenchmarkHandleEventManyOutputs measures handling a transaction with many domain outputs, both
+// sequentially and with the output worker pool
+func BenchmarkHandleEventManyOutputs(b *testing.B) {
+	const (
+		outputCount      = 200
+		recordsPerOutput = 20
+	)
+	i, tldAddr, tldPolicyId := setupTestIndexer(b)
+	outputs := make([]ledger.TransactionOutput, 0, outputCount)
+	for idx := 0; idx < outputCount; idx++ {
+		origin := fmt.Sprintf("domain%d", idx)
+		recordNames := make([]string, 0, recordsPerOutput)
+		for recordIdx := 0; recordIdx < recordsPerOutput; recordIdx++ {
+			recordNames = append(
+				recordNames,
+				fmt.Sprintf("host%d.%s.%s.", recordIdx, origin, testTld),
+			)
+		}
+		outputs = append(
+			outputs,
+			testOutput(
+				b,
+				tldAddr,
+				tldPolicyId,
+				[]byte(origin),
+				testDomainDatum(origin, recordNames...),
+			),
+		)
+	}
+	evt := testEvent("bench", outputs...)
+	for _, outputWorkers := range []int{1, 4, 8} {
+		b.Run(
+			fmt.Sprintf("workers=%d", outputWorkers),
+			func(b *testing.B) {
+				config.GetConfig().Indexer.OutputWorkers = outputWorkers
+				for n := 0; n < b.N; n++ {
+					if err := i.handleEvent(evt); err != nil {
+						b.Fatalf("unexpected error: %s", err)
+					}
+				}
+			},
+		)
+	}
+}
+
+func TestMain(m *testing.M) {
+	// Discard the per-domain log messages
+	slog.SetDefault(slog.New(slog.NewTextHandler(io.Discard, nil)))
+	os.Exit(m.Run())
+}
+
+func TestConcurrentDiscovery(t *testing.T) {
+	const discoveryCount = 40
+	i, tldAddr, tldPolicyId := setupTestIndexer(t)
+	config.GetConfig().Indexer.OutputWorkers = 4
+	t.Cleanup(func() {
+		config.GetConfig().Indexer.OutputWorkers = 0
+	})
+	// Watch a discovery address alongside the static TLD
+	discoveryAddr := testScriptAddress(t, testHash("discovery-script"))
+	discoveryPolicyId := testHash("discovery-policy")
+	i.watched[discoveryAddr.String()] = watchedAddr{
+		Address:   discoveryAddr.String(),
+		PolicyId:  fmt.Sprintf("%x", discoveryPolicyId),
+		Discovery: true,
+	}
+	var wg sync.WaitGroup
+	errChan := make(chan error, 4)
+	// Discover new TLDs from two goroutines, two per transaction
+	for _, startIdx := range []int{0, discoveryCount / 2} {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for idx := startIdx; idx < startIdx+discoveryCount/2; idx += 2 {
+				outputs := []ledger.TransactionOutput{}
+				for _, tldIdx := range []int{idx, idx + 1} {
+					outputs = append(
+						outputs,
+						testOutput(
+							t,
+							discoveryAddr,
+							discoveryPolicyId,
+							testHash(fmt.Sprintf("tld%d-script", tldIdx)),
+							testDiscoveryDatum(
+								fmt.Sprintf("tld%d", tldIdx),
+								testHash(fmt.Sprintf("tld%d-policy", tldIdx)),
+							),
+						),
+					)
+				}
+				if err := i.handleEvent(testEvent(fmt.Sprintf("discovery%d", idx), outputs...)); err != nil {
+					errChan <- err
+					return
+				}
+			}
+		}()
+	}
+	// Process domain updates for the static TLD at the same time
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		for idx := 0; idx < discoveryCount; idx++ {
+			origin := fmt.Sprintf("domain%d", idx)
+			evt := testEvent(
+				fmt.Sprintf("domain%d", idx),
+				testOutput(
+					t,
+					tldAddr,
+					tldPolicyId,
+					[]byte(origin),
+					testDomainDatum(origin, fmt.Sprintf("%s.%s.", origin, testTld)),
+				),
+				testOutput(
+					t,
+					discoveryAddr,
+					discoveryPolicyId,
+					[]byte("not-a-script-hash"),
+					testDomainDatum(origin),
+				),
+			)
+			if err := i.handleEvent(evt); err != nil {
+				errChan <- err
+				return
+			}
+		}
+	}()
+	// Read the watched TLDs at the same time
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		for idx := 0; idx < discoveryCount; idx++ {
+			_ = i.WatchedTlds()
+		}
+	}()
+	wg.Wait()
+	close(errChan)
+	for err := range errChan {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	// Static TLD, discovery address, and each discovered TLD
+	if watchedCount := len(i.WatchedTlds()); watchedCount != discoveryCount+2 {
+		t.Fatalf("expected %d watched addresses, got %d", discoveryCount+2, watchedCount)
+	}
+	discoveredAddrs, err := state.GetState().GetDiscoveredAddresses()
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	if len(discoveredAddrs) != discoveryCount {
+		t.Fatalf("expected %d discovered addresses, got %d", discoveryCount, len(discoveredAddrs))
+	}
+}
+
+func TestQualifyRecordName(t *testing.T) {
+	testDefs := []struct {
+		lhs      string
+		expected string
+		rejected bool
+	}{
+		// Zone apex
+		{lhs: "", expected: "example.test."},
+		{lhs: "@", expected: "example.test."},
+		// Absolute names within the origin, with and without a trailing dot
+		{lhs: "www.example.test.", expected: "www.example.test."},
+		{lhs: "www.example.test", expected: "www.example.test."},
+		{lhs: "WWW.Example.Test", expected: "www.example.test."},
+		{lhs: "example.test", expected: "example.test."},
+		// Names outside of the origin are not qualified with it, so the records get dropped
+		{lhs: "www.other.test", expected: "www.other.test.", rejected: true},
+		{lhs: "www.other.test.", expected: "www.other.test.", rejected: true},
+		{lhs: "www", expected: "www.", rejected: true},
+	}
+	for _, testDef := range testDefs {
+		name := qualifyRecordName(testDef.lhs, "example.test.")
+		if name != testDef.expected {
+			t.Errorf("qualifying %q: expected %q, got %q", testDef.lhs, testDef.expected, name)
+		}
+		if rejected := !dns.IsSubDomain("example.test.", name); rejected != testDef.rejected {
+			t.Errorf("qualifying %q: expected rejected=%t, got %t", testDef.lhs, testDef.rejected, rejected)
+		}
+	}
+}
+
+func TestApexRecords(t *testing.T) {
+	i, tldAddr, tldPolicyId := setupTestIndexer(t)
+	// Two domains with apex records using each form of LHS, and one with a record outside of its origin
+	evt := testEvent(
+		"apex",
+		testOutput(t, tldAddr, tldPolicyId, []byte("example"), testDomainDatum("example", "@", "www.example.test")),
+		testOutput(t, tldAddr, tldPolicyId, []byte("other"), testDomainDatum("other", "", "other.test.")),
+		testOutput(t, tldAddr, tldPolicyId, []byte("bad"), testDomainDatum("bad", "bad.test", "www.other.test")),
+	)
+	if err := i.handleEvent(evt); err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	testDefs := []struct {
+		name     string
+		expected []string
+	}{
+		{name: "example.test.", expected: []string{"192.0.2.1"}},
+		{name: "www.example.test.", expected: []string{"192.0.2.2"}},
+		{name: "other.test.", expected: []string{"192.0.2.1", "192.0.2.2"}},
+		{name: "bad.test.", expected: nil},
+		{name: "www.other.test.", expected: nil},
+	}
+	for _, testDef := range testDefs {
+		records, err := state.GetState().LookupRecords([]string{"A"}, testDef.name)
+		if err != nil {
+			t.Fatalf("unexpected error: %s", err)
+		}
+		var addresses []string
+		for _, record := range records {
+			addresses = append(addresses, record.Rhs)
+		}
+		slices.Sort(addresses)
+		if !slices.Equal(addresses, testDef.expected) {
+			t.Errorf("looking up %s: expected %v, got %v", testDef.name, testDef.expected, addresses)
+		}
+	}
+}
+
+func TestPipelineRestart(t *testing.T) {
+	i, _, _ := setupTestIndexer(t)
+	var running atomic.Int64
+	inputs := make(chan *testInput, 10)
+	origNewPipelineInput := newPipelineInput
+	newPipelineInput = func(...input_chainsync.ChainSyncOptionFunc) plugin.Plugin {
+		in := &testInput{
+			errorChan:  make(chan error),
+			outputChan: make(chan event.Event),
+			running:    &running,
+		}
+		inputs <- in
+		return in
+	}
+	t.Cleanup(func() {
+		i.pipelineMutex.Lock()
+		i.stopPipeline()
+		i.pipelineMutex.Unlock()
+		newPipelineInput = origNewPipelineInput
+	})
+	if err := i.startPipeline(); err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	firstInput := <-inputs
+	// Force a pipeline error and wait for the restart after the backoff delay
+	firstInput.errorChan <- errors.New("test error")
+	select {
+	case <-inputs:
+	case <-time.After(pipelineRestartMinDelay + 5*time.Second):
+		t.Fatal("timed out waiting for the pipeline to restart")
+	}
+	// Wait for the restarted pipeline to finish starting
+	i.pipelineMutex.Lock()
+	restarted := i.pipeline != nil
+	i.pipelineMutex.Unlock()
+	if !restarted {
+		t.Fatal("expected a running pipeline after the restart")
+	}
+	if failures := i.pipelineFailures.Load(); failures != 1 {
+		t.Fatalf("expected 1 pipeline failure, got %d", failures)
+	}
+	if runningCount := running.Load(); runningCount != 1 {
+		t.Fatalf("expected 1 running pipeline after the restart, got %d", runningCount)
+	}
+	// Starting a pipeline again stops the running one first
+	if err := i.startPipeline(); err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	<-inputs
+	if runningCount := running.Load(); runningCount != 1 {
+		t.Fatalf("expected 1 running pipeline after replacing it, got %d", runningCount)
+	}
+}
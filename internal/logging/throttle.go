@@ -0,0 +1,120 @@
+// Copyright 2024 Blink Labs Software
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package logging
+
+import (
+	"fmt"
+	"log/slog"
+	"sync"
+	"time"
+
+	"github.com/blinklabs-io/cdnsd/internal/config"
+)
+
+// Maximum number of distinct warnings tracked for throttling
+const maxThrottledWarnings = 1000
+
+type warnThrottle struct {
+	sync.Mutex
+	entries     map[string]*throttledWarning
+	flushTicker *time.Ticker
+}
+
+type throttledWarning struct {
+	firstSeen  time.Time
+	suppressed int
+}
+
+var globalWarnThrottle = &warnThrottle{
+	entries: map[string]*throttledWarning{},
+}
+
+// Warnf logs a warning, collapsing identical warnings within the configured window. The number of
+// suppressed warnings is logged once the window has passed
+func Warnf(format string, args ...any) {
+	window := config.GetConfig().Logging.WarnThrottleWindow
+	if window <= 0 {
+		slog.Warn(fmt.Sprintf(format, args...))
+		return
+	}
+	globalWarnThrottle.warnf(window, fmt.Sprintf(format, args...))
+}
+
+func (t *warnThrottle) warnf(window time.Duration, msg string) {
+	t.Lock()
+	defer t.Unlock()
+	// Flush expired entries in the background, so that the suppressed count is logged even if the
+	// warnings stop
+	if t.flushTicker == nil {
+		t.flushTicker = time.NewTicker(window)
+		go t.flushExpired(t.flushTicker, window)
+	}
+	now := time.Now()
+	if entry, ok := t.entries[msg]; ok {
+		if now.Sub(entry.firstSeen) < window {
+			entry.suppressed++
+			return
+		}
+		t.evict(msg)
+	}
+	// Evict the oldest entry if we're tracking too many
+	if len(t.entries) >= maxThrottledWarnings {
+		var oldestKey string
+		var oldestTime time.Time
+		for key, entry := range t.entries {
+			if oldestKey == "" || entry.firstSeen.Before(oldestTime) {
+				oldestKey = key
+				oldestTime = entry.firstSeen
+			}
+		}
+		t.evict(oldestKey)
+	}
+	t.entries[msg] = &throttledWarning{
+		firstSeen: now,
+	}
+	slog.Warn(msg)
+}
+
+// flushExpired periodically evicts tracked warnings whose window has passed
+func (t *warnThrottle) flushExpired(ticker *time.Ticker, window time.Duration) {
+	for now := range ticker.C {
+		t.Lock()
+		for key, entry := range t.entries {
+			if now.Sub(entry.firstSeen) >= window {
+				t.evict(key)
+			}
+		}
+		t.Unlock()
+	}
+}
+
+// evict removes a tracked warning, logging the number of suppressed warnings if any
+func (t *warnThrottle) evict(key string) {
+	entry, ok := t.entries[key]
+	if !ok {
+		return
+	}
+	delete(t.entries, key)
+	if entry.suppressed > 0 {
+		slog.Warn(
+			fmt.Sprintf(
+				"suppressed %d repeated warnings since %s: %s",
+				entry.suppressed,
+				entry.firstSeen.Format(time.RFC3339),
+				key,
+			),
+		)
+	}
+}
@@ -0,0 +1,122 @@
+// Copyright 2024 Blink Labs Software
+//
+// Use of this source code is governed by an MIT-style
+// license that can be found in the LICENSE file or at
+// https://opensource.org/licenses/MIT.
+
+package state
+
+import (
+	"bytes"
+	"encoding/gob"
+	"errors"
+	"fmt"
+	"log/slog"
+	"strings"
+
+	"github.com/dgraph-io/badger/v4"
+)
+
+// recordChanges describes the differences between the previous and new records for a domain
+type recordChanges struct {
+	added    []DomainRecord
+	removed  []DomainRecord
+	modified []DomainRecord
+}
+
+// diffRecords compares the previous and new records for a domain. Records are matched by name, type,
+// and value, and matching records with a different TTL, class, or comment are considered modified
+func diffRecords(oldRecords []DomainRecord, newRecords []DomainRecord) recordChanges {
+	var ret recordChanges
+	oldByKey := map[string]DomainRecord{}
+	for _, record := range oldRecords {
+		oldByKey[record.diffKey()] = record
+	}
+	for _, record := range newRecords {
+		oldRecord, ok := oldByKey[record.diffKey()]
+		if !ok {
+			ret.added = append(ret.added, record)
+			continue
+		}
+		delete(oldByKey, record.diffKey())
+		if oldRecord != record {
+			ret.modified = append(ret.modified, record)
+		}
+	}
+	// Keep removed records in their original order
+	for _, record := range oldRecords {
+		if _, ok := oldByKey[record.diffKey()]; ok {
+			ret.removed = append(ret.removed, record)
+		}
+	}
+	return ret
+}
+
+// log writes an audit log summarizing the record changes for a domain
+func (c recordChanges) log(domainName string) {
+	if len(c.added) == 0 && len(c.removed) == 0 && len(c.modified) == 0 {
+		return
+	}
+	slog.Info(
+		fmt.Sprintf(
+			"records changed for domain %s: %d added, %d removed, %d modified",
+			domainName,
+			len(c.added),
+			len(c.removed),
+			len(c.modified),
+		),
+	)
+	for _, record := range c.added {
+		slog.Info(
+			fmt.Sprintf("added record for domain %s: %s", domainName, record.String()),
+		)
+	}
+	for _, record := range c.removed {
+		slog.Info(
+			fmt.Sprintf("removed record for domain %s: %s", domainName, record.String()),
+		)
+	}
+	for _, record := range c.modified {
+		slog.Info(
+			fmt.Sprintf("modified record for domain %s: %s", domainName, record.String()),
+		)
+	}
+}
+
+// diffKey returns the identity of a record for comparing record sets
+func (r DomainRecord) diffKey() string {
+	return fmt.Sprintf(
+		"%s %s %s",
+		strings.ToLower(strings.TrimSuffix(r.Lhs, ".")),
+		strings.ToUpper(r.Type),
+		r.Rhs,
+	)
+}
+
+func (r DomainRecord) String() string {
+	return fmt.Sprintf("%s %d %s %s", r.Lhs, r.Ttl, r.Type, r.Rhs)
+}
+
+// getRecords returns the stored records for the specified record keys, skipping any missing keys
+func getRecords(txn *badger.Txn, recordKeys []string) ([]DomainRecord, error) {
+	var ret []DomainRecord
+	for _, recordKey := range recordKeys {
+		item, err := txn.Get([]byte(recordKey))
+		if err != nil {
+			if errors.Is(err, badger.ErrKeyNotFound) {
+				continue
+			}
+			return nil, err
+		}
+		val, err := item.ValueCopy(nil)
+		if err != nil {
+			return nil, err
+		}
+		var tmpRecord DomainRecord
+		if err := gob.NewDecoder(bytes.NewReader(val)).Decode(&tmpRecord); err != nil {
+			return nil, err
+		}
+		ret = append(ret, tmpRecord)
+	}
+	return ret, nil
+}
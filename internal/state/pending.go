@@ -0,0 +1,133 @@
+// Copyright 2024 Blink Labs Software
+//
+// Use of this source code is governed by an MIT-style
+// license that can be found in the LICENSE file or at
+// https://opensource.org/licenses/MIT.
+
+package state
+
+import (
+	"bytes"
+	"encoding/gob"
+	"fmt"
+
+	"github.com/dgraph-io/badger/v4"
+)
+
+const pendingKeyPrefix = "p_"
+
+// pendingDomainUpdate is a domain update waiting for enough confirmations to be applied
+type pendingDomainUpdate struct {
+	DomainName  string
+	SlotNumber  uint64
+	BlockNumber uint64
+	Records     []DomainRecord
+}
+
+// QueueDomainUpdate stores a domain update from the specified block, to be applied by
+// ApplyPendingDomainUpdates once the block is confirmed
+func (s *State) QueueDomainUpdate(
+	domainName string,
+	records []DomainRecord,
+	slotNumber uint64,
+	blockNumber uint64,
+) error {
+	update := pendingDomainUpdate{
+		DomainName:  domainName,
+		SlotNumber:  slotNumber,
+		BlockNumber: blockNumber,
+		Records:     records,
+	}
+	var gobBuf bytes.Buffer
+	gobEnc := gob.NewEncoder(&gobBuf)
+	if err := gobEnc.Encode(&update); err != nil {
+		return err
+	}
+	err := s.db.Update(func(txn *badger.Txn) error {
+		return txn.Set(
+			[]byte(pendingKey(slotNumber, domainName)),
+			gobBuf.Bytes(),
+		)
+	})
+	return err
+}
+
+// ApplyPendingDomainUpdates applies queued domain updates from blocks up to and including the specified
+// block number, in chain order, and returns the number applied
+func (s *State) ApplyPendingDomainUpdates(confirmedBlock uint64) (int, error) {
+	var updateKeys [][]byte
+	var updates []pendingDomainUpdate
+	err := s.db.View(func(txn *badger.Txn) error {
+		it := txn.NewIterator(badger.DefaultIteratorOptions)
+		defer it.Close()
+		keyPrefix := []byte(pendingKeyPrefix)
+		for it.Seek(keyPrefix); it.ValidForPrefix(keyPrefix); it.Next() {
+			item := it.Item()
+			val, err := item.ValueCopy(nil)
+			if err != nil {
+				return err
+			}
+			gobDec := gob.NewDecoder(bytes.NewReader(val))
+			var tmpUpdate pendingDomainUpdate
+			if err := gobDec.Decode(&tmpUpdate); err != nil {
+				return err
+			}
+			// Keys are in slot order, so everything after this is also unconfirmed
+			if tmpUpdate.BlockNumber > confirmedBlock {
+				break
+			}
+			updateKeys = append(updateKeys, item.KeyCopy(nil))
+			updates = append(updates, tmpUpdate)
+		}
+		return nil
+	})
+	if err != nil {
+		return 0, err
+	}
+	for idx, update := range updates {
+		if err := s.UpdateDomain(update.DomainName, update.Records); err != nil {
+			return idx, err
+		}
+		err := s.db.Update(func(txn *badger.Txn) error {
+			return txn.Delete(updateKeys[idx])
+		})
+		if err != nil {
+			return idx, err
+		}
+	}
+	return len(updates), nil
+}
+
+// DiscardPendingDomainUpdates removes queued domain updates from after the specified slot, such as
+// when those blocks are rolled back, and returns the number removed
+func (s *State) DiscardPendingDomainUpdates(afterSlot uint64) (int, error) {
+	var discarded int
+	err := s.db.Update(func(txn *badger.Txn) error {
+		it := txn.NewIterator(badger.IteratorOptions{})
+		defer it.Close()
+		// Start with the first key after the specified slot
+		keyPrefix := []byte(pendingKeyPrefix)
+		for it.Seek([]byte(pendingKey(afterSlot+1, ""))); it.ValidForPrefix(keyPrefix); it.Next() {
+			if err := txn.Delete(it.Item().KeyCopy(nil)); err != nil {
+				return err
+			}
+			discarded++
+		}
+		return nil
+	})
+	if err != nil {
+		return 0, err
+	}
+	return discarded, nil
+}
+
+// pendingKey returns the key for a queued domain update. The slot is zero-padded so that keys sort in
+// chain order
+func pendingKey(slotNumber uint64, domainName string) string {
+	return fmt.Sprintf(
+		"%s%020d_%s",
+		pendingKeyPrefix,
+		slotNumber,
+		domainName,
+	)
+}
@@ -8,6 +8,8 @@ package state
 
 import (
 	"bytes"
+	"crypto/sha256"
+	"encoding/binary"
 	"encoding/gob"
 	"encoding/json"
 	"errors"
@@ -16,21 +18,30 @@ import (
 	"slices"
 	"strconv"
 	"strings"
+	"sync"
 	"time"
 
 	"github.com/blinklabs-io/cdnsd/internal/config"
 	"github.com/dgraph-io/badger/v4"
+	"github.com/miekg/dns"
 )
 
 const (
 	chainsyncCursorKey = "chainsync_cursor"
 	discoveredAddrKey  = "discovered_addresses"
 	fingerprintKey     = "config_fingerprint"
+	nameIndexKey       = "name_index_built"
 )
 
 type State struct {
-	db      *badger.DB
-	gcTimer *time.Ticker
+	db         *badger.DB
+	gcTimer    *time.Ticker
+	pruneTimer *time.Ticker
+	// Prevents pruning from racing with domain updates
+	recordsMutex sync.Mutex
+	// Serializes read-modify-write updates of the discovered addresses list
+	discoveredMutex sync.Mutex
+	stats           stateStats
 }
 
 type DomainRecord struct {
@@ -38,19 +49,37 @@ type DomainRecord struct {
 	Type string
 	Ttl  int
 	Rhs  string
+	// Optional metadata from the on-chain datum
+	Class   string
+	Comment string
 }
 
 type DiscoveredAddress struct {
 	Address  string
 	TldName  string
 	PolicyId string
+	// UTxO currently holding the discovery asset, used to detect retirement
+	TxHash      string
+	TxOutputIdx uint32
+	// Slot where the discovery asset was last seen
+	LastSeenSlot uint64
 }
 
 var globalState = &State{}
 
 func (s *State) Load() error {
 	cfg := config.GetConfig()
-	badgerOpts := badger.DefaultOptions(cfg.State.Directory).
+	return s.open(badger.DefaultOptions(cfg.State.Directory))
+}
+
+// LoadInMemory opens a temporary in-memory database, such as for tests, which is discarded on close
+func (s *State) LoadInMemory() error {
+	return s.open(badger.DefaultOptions("").WithInMemory(true))
+}
+
+func (s *State) open(badgerOpts badger.Options) error {
+	cfg := config.GetConfig()
+	badgerOpts = badgerOpts.
 		WithLogger(NewBadgerLogger()).
 		// The default INFO logging is a bit verbose
 		WithLoggingLevel(badger.WARNING)
@@ -63,39 +92,198 @@ func (s *State) Load() error {
 	if err := s.compareFingerprint(); err != nil {
 		return err
 	}
+	// Build record name index for older DBs
+	if err := s.buildNameIndex(); err != nil {
+		return err
+	}
+	if err := s.loadStats(); err != nil {
+		return err
+	}
 	// Run GC periodically for Badger DB
-	s.gcTimer = time.NewTicker(5 * time.Minute)
+	gcTimer := time.NewTicker(5 * time.Minute)
+	s.gcTimer = gcTimer
 	go func() {
-		for range s.gcTimer.C {
-		again:
-			slog.Debug("database: running GC")
-			err := s.db.RunValueLogGC(0.5)
-			if err != nil {
-				// Log any actual errors
-				if !errors.Is(err, badger.ErrNoRewrite) {
+		for range gcTimer.C {
+			if _, err := s.RunGC(); err != nil {
+				slog.Warn(
+					fmt.Sprintf(
+						"database: GC failure: %s",
+						err,
+					),
+				)
+			}
+		}
+	}()
+	// Prune orphaned record keys periodically, if configured
+	if cfg.State.PruneInterval > 0 {
+		pruneTimer := time.NewTicker(cfg.State.PruneInterval)
+		s.pruneTimer = pruneTimer
+		go func() {
+			for range pruneTimer.C {
+				if _, err := s.PruneOrphanedRecords(); err != nil {
 					slog.Warn(
 						fmt.Sprintf(
-							"database: GC failure: %s",
+							"database: prune failure: %s",
 							err,
 						),
 					)
 				}
-			} else {
-				// Run it again if it just ran successfully
-				goto again
 			}
-		}
-	}()
+		}()
+	}
 	return nil
 }
 
+// Close stops background maintenance and closes the database
+func (s *State) Close() error {
+	if s.gcTimer != nil {
+		s.gcTimer.Stop()
+	}
+	if s.pruneTimer != nil {
+		s.pruneTimer.Stop()
+	}
+	return s.db.Close()
+}
+
+// RunGC runs value log GC until there is nothing left to rewrite and returns the number of successful runs
+func (s *State) RunGC() (int, error) {
+	runs := 0
+	for {
+		slog.Debug("database: running GC")
+		err := s.db.RunValueLogGC(0.5)
+		if err != nil {
+			if errors.Is(err, badger.ErrNoRewrite) {
+				return runs, nil
+			}
+			return runs, err
+		}
+		// Run it again if it just ran successfully
+		runs++
+	}
+}
+
+type DbStats struct {
+	LsmSize  int64 `json:"lsmSize"`
+	VlogSize int64 `json:"vlogSize"`
+	KeyCount int   `json:"keyCount"`
+}
+
+// DbStats returns size information and key count for the database
+func (s *State) DbStats() (DbStats, error) {
+	var ret DbStats
+	ret.LsmSize, ret.VlogSize = s.db.Size()
+	err := s.db.View(func(txn *badger.Txn) error {
+		itOpts := badger.DefaultIteratorOptions
+		itOpts.PrefetchValues = false
+		it := txn.NewIterator(itOpts)
+		defer it.Close()
+		for it.Rewind(); it.Valid(); it.Next() {
+			ret.KeyCount++
+		}
+		return nil
+	})
+	if err != nil {
+		return ret, err
+	}
+	return ret, nil
+}
+
+// PruneOrphanedRecords deletes any record keys that aren't referenced by a domain tracking key
+// and returns the number of records pruned
+func (s *State) PruneOrphanedRecords() (int, error) {
+	s.recordsMutex.Lock()
+	defer s.recordsMutex.Unlock()
+	var orphanedKeys [][]byte
+	err := s.db.View(func(txn *badger.Txn) error {
+		// Gather all record keys referenced by domain tracking keys
+		referencedKeys := make(map[string]bool)
+		domainPrefix := []byte("d_")
+		it := txn.NewIterator(badger.DefaultIteratorOptions)
+		defer it.Close()
+		for it.Seek(domainPrefix); it.ValidForPrefix(domainPrefix); it.Next() {
+			item := it.Item()
+			if !strings.HasSuffix(string(item.Key()), "_records") {
+				continue
+			}
+			val, err := item.ValueCopy(nil)
+			if err != nil {
+				return err
+			}
+			for _, tmpRecordKey := range strings.Split(string(val), ",") {
+				if tmpRecordKey == "" {
+					continue
+				}
+				referencedKeys[tmpRecordKey] = true
+			}
+		}
+		// Find record keys with no reference
+		recordPrefix := []byte("r_")
+		keyItOpts := badger.DefaultIteratorOptions
+		keyItOpts.PrefetchValues = false
+		keyIt := txn.NewIterator(keyItOpts)
+		defer keyIt.Close()
+		for keyIt.Seek(recordPrefix); keyIt.ValidForPrefix(recordPrefix); keyIt.Next() {
+			key := keyIt.Item().KeyCopy(nil)
+			if !referencedKeys[string(key)] {
+				orphanedKeys = append(orphanedKeys, key)
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return 0, err
+	}
+	if len(orphanedKeys) == 0 {
+		return 0, nil
+	}
+	// Use a write batch to avoid hitting transaction size limits
+	wb := s.db.NewWriteBatch()
+	defer wb.Cancel()
+	for _, key := range orphanedKeys {
+		if err := wb.Delete(key); err != nil {
+			return 0, err
+		}
+	}
+	if err := wb.Flush(); err != nil {
+		return 0, err
+	}
+	slog.Info(
+		fmt.Sprintf(
+			"database: pruned %d orphaned records",
+			len(orphanedKeys),
+		),
+	)
+	return len(orphanedKeys), nil
+}
+
 func (s *State) compareFingerprint() error {
 	cfg := config.GetConfig()
-	fingerprint := fmt.Sprintf(
+	// Older versions only fingerprinted the network
+	legacyFingerprint := fmt.Sprintf(
 		"network=%s,network-magic=%d",
 		cfg.Indexer.Network,
 		cfg.Indexer.NetworkMagic,
 	)
+	// Include the served TLDs and policy IDs from enabled profiles
+	var tlds []string
+	var policyIds []string
+	for _, profile := range config.GetProfiles() {
+		if profile.Tld != "" {
+			tlds = append(tlds, profile.Tld)
+		}
+		if profile.PolicyId != "" {
+			policyIds = append(policyIds, profile.PolicyId)
+		}
+	}
+	slices.Sort(tlds)
+	slices.Sort(policyIds)
+	fingerprint := fmt.Sprintf(
+		"%s,tlds=%s,policy-ids=%s",
+		legacyFingerprint,
+		strings.Join(tlds, "|"),
+		strings.Join(policyIds, "|"),
+	)
+	var wipe bool
 	err := s.db.Update(func(txn *badger.Txn) error {
 		item, err := txn.Get([]byte(fingerprintKey))
 		if err != nil {
@@ -108,23 +296,56 @@ func (s *State) compareFingerprint() error {
 				return err
 			}
 		}
-		err = item.Value(func(v []byte) error {
-			if string(v) != fingerprint {
-				return fmt.Errorf(
-					"config fingerprint in DB doesn't match current config: %s",
-					v,
-				)
-			}
-			return nil
-		})
+		dbFingerprint, err := item.ValueCopy(nil)
 		if err != nil {
 			return err
 		}
-		return nil
+		if string(dbFingerprint) == fingerprint {
+			return nil
+		}
+		switch {
+		case cfg.State.ResetFingerprint:
+			slog.Warn(
+				fmt.Sprintf(
+					"database: resetting config fingerprint: %s",
+					dbFingerprint,
+				),
+			)
+		case string(dbFingerprint) == legacyFingerprint:
+			slog.Info("database: upgrading config fingerprint")
+		case cfg.State.OnFingerprintMismatch == config.FingerprintMismatchWipe:
+			// The DB is wiped outside of this transaction
+			slog.Warn(
+				fmt.Sprintf(
+					"database: config fingerprint in DB doesn't match current config, wiping DB: %s",
+					dbFingerprint,
+				),
+			)
+			wipe = true
+			return nil
+		default:
+			return fmt.Errorf(
+				"config fingerprint in DB doesn't match current config: %s",
+				dbFingerprint,
+			)
+		}
+		return txn.Set([]byte(fingerprintKey), []byte(fingerprint))
 	})
 	if err != nil {
 		return err
 	}
+	if wipe {
+		if err := s.db.DropAll(); err != nil {
+			return err
+		}
+		err := s.db.Update(func(txn *badger.Txn) error {
+			return txn.Set([]byte(fingerprintKey), []byte(fingerprint))
+		})
+		if err != nil {
+			return err
+		}
+		slog.Info("database: wiped DB, indexing will start from the configured intercept point")
+	}
 	return nil
 }
 
@@ -168,13 +389,62 @@ func (s *State) GetCursor() (uint64, string, error) {
 	return slotNumber, blockHash, err
 }
 
+// ClearDomainRecords removes all indexed domain records and related tracking keys,
+// leaving the chainsync cursor and discovered addresses in place
+func (s *State) ClearDomainRecords() error {
+	s.recordsMutex.Lock()
+	defer s.recordsMutex.Unlock()
+	err := s.db.DropPrefix(
+		[]byte("r_"),
+		[]byte("d_"),
+		[]byte("n_"),
+		[]byte("z_"),
+		[]byte(pendingKeyPrefix),
+	)
+	if err != nil {
+		return err
+	}
+	s.stats.domains.Store(0)
+	s.stats.records.Store(0)
+	return nil
+}
+
 func (s *State) AddDiscoveredAddress(addr DiscoveredAddress) error {
+	s.discoveredMutex.Lock()
+	defer s.discoveredMutex.Unlock()
 	tmpAddrs, err := s.GetDiscoveredAddresses()
 	if err != nil {
 		return err
 	}
+	// Replace any existing entry for the same address
+	tmpAddrs = slices.DeleteFunc(
+		tmpAddrs,
+		func(tmpAddr DiscoveredAddress) bool {
+			return tmpAddr.Address == addr.Address
+		},
+	)
 	tmpAddrs = append(tmpAddrs, addr)
-	tmpAddrsJson, err := json.Marshal(&tmpAddrs)
+	return s.putDiscoveredAddresses(tmpAddrs)
+}
+
+func (s *State) RemoveDiscoveredAddress(address string) error {
+	s.discoveredMutex.Lock()
+	defer s.discoveredMutex.Unlock()
+	tmpAddrs, err := s.GetDiscoveredAddresses()
+	if err != nil {
+		return err
+	}
+	tmpAddrs = slices.DeleteFunc(
+		tmpAddrs,
+		func(tmpAddr DiscoveredAddress) bool {
+			return tmpAddr.Address == address
+		},
+	)
+	return s.putDiscoveredAddresses(tmpAddrs)
+}
+
+func (s *State) putDiscoveredAddresses(addrs []DiscoveredAddress) error {
+	tmpAddrsJson, err := json.Marshal(&addrs)
 	if err != nil {
 		return err
 	}
@@ -187,6 +457,7 @@ func (s *State) AddDiscoveredAddress(addr DiscoveredAddress) error {
 	if err != nil {
 		return err
 	}
+	s.stats.discoveredAddresses.Store(int64(len(addrs)))
 	return nil
 }
 
@@ -217,24 +488,82 @@ func (s *State) UpdateDomain(
 	domainName string,
 	records []DomainRecord,
 ) error {
+	s.recordsMutex.Lock()
+	defer s.recordsMutex.Unlock()
+	// Encode records and hash the resulting record set
+	recordVals := make([][]byte, 0, len(records))
+	recordsHash := sha256.New()
+	for _, record := range records {
+		var gobBuf bytes.Buffer
+		gobEnc := gob.NewEncoder(&gobBuf)
+		if err := gobEnc.Encode(&record); err != nil {
+			return err
+		}
+		recordVal := gobBuf.Bytes()[:]
+		recordVals = append(recordVals, recordVal)
+		// Length prefix each record to avoid ambiguity between record boundaries
+		if err := binary.Write(recordsHash, binary.BigEndian, uint32(len(recordVal))); err != nil {
+			return err
+		}
+		recordsHash.Write(recordVal)
+	}
+	recordsHashVal := recordsHash.Sum(nil)
+	var newDomain bool
+	var oldRecordCount int
+	var unchanged bool
+	var changes recordChanges
 	err := s.db.Update(func(txn *badger.Txn) error {
+		// Skip the update if the stored record set is identical, such as when replaying blocks
+		domainHashKey := []byte(fmt.Sprintf("d_%s_hash", domainName))
+		hashItem, err := txn.Get(domainHashKey)
+		if err != nil {
+			if !errors.Is(err, badger.ErrKeyNotFound) {
+				return err
+			}
+		} else {
+			storedHash, err := hashItem.ValueCopy(nil)
+			if err != nil {
+				return err
+			}
+			if bytes.Equal(storedHash, recordsHashVal) {
+				unchanged = true
+				return nil
+			}
+		}
+		if err := txn.Set(domainHashKey, recordsHashVal); err != nil {
+			return err
+		}
+		// Get previous records for this domain
+		domainRecordsKey := []byte(fmt.Sprintf("d_%s_records", domainName))
+		if _, err := txn.Get(domainRecordsKey); err != nil {
+			if !errors.Is(err, badger.ErrKeyNotFound) {
+				return err
+			}
+			newDomain = true
+		}
+		oldRecordKeys, err := getKeyList(txn, domainRecordsKey)
+		if err != nil {
+			return err
+		}
+		oldRecordCount = len(oldRecordKeys)
+		// Determine record changes for existing domains before overwriting any records
+		if !newDomain {
+			oldRecords, err := getRecords(txn, oldRecordKeys)
+			if err != nil {
+				return err
+			}
+			changes = diffRecords(oldRecords, records)
+		}
 		// Add new records
 		recordKeys := make([]string, 0)
 		for recordIdx, record := range records {
 			key := fmt.Sprintf(
-				"r_%s_%s_%d",
-				strings.ToUpper(record.Type),
-				strings.Trim(record.Lhs, `.`),
+				"%s%d",
+				recordKeyPrefix(record.Type, record.Lhs),
 				recordIdx,
 			)
 			recordKeys = append(recordKeys, key)
-			var gobBuf bytes.Buffer
-			gobEnc := gob.NewEncoder(&gobBuf)
-			if err := gobEnc.Encode(&record); err != nil {
-				return err
-			}
-			recordVal := gobBuf.Bytes()[:]
-			if err := txn.Set([]byte(key), recordVal); err != nil {
+			if err := txn.Set([]byte(key), recordVals[recordIdx]); err != nil {
 				return err
 			}
 			slog.Debug(
@@ -248,26 +577,10 @@ func (s *State) UpdateDomain(
 			)
 		}
 		// Delete old records in tracking key that are no longer present after this update
-		domainRecordsKey := []byte(fmt.Sprintf("d_%s_records", domainName))
-		domainRecordsItem, err := txn.Get(domainRecordsKey)
-		if err != nil {
-			if !errors.Is(err, badger.ErrKeyNotFound) {
-				return err
-			}
-		} else {
-			domainRecordsVal, err := domainRecordsItem.ValueCopy(nil)
-			if err != nil {
-				return err
-			}
-			domainRecordsSplit := strings.Split(string(domainRecordsVal), ",")
-			for _, tmpRecordKey := range domainRecordsSplit {
-				if tmpRecordKey == "" {
-					continue
-				}
-				if !slices.Contains(recordKeys, tmpRecordKey) {
-					if err := txn.Delete([]byte(tmpRecordKey)); err != nil {
-						return err
-					}
+		for _, tmpRecordKey := range oldRecordKeys {
+			if !slices.Contains(recordKeys, tmpRecordKey) {
+				if err := txn.Delete([]byte(tmpRecordKey)); err != nil {
+					return err
 				}
 			}
 		}
@@ -276,9 +589,129 @@ func (s *State) UpdateDomain(
 		if err := txn.Set(domainRecordsKey, []byte(recordKeysJoin)); err != nil {
 			return err
 		}
+		// Update per-name index keys for old and new record names
+		newNameKeys := map[string][]string{}
+		for _, tmpRecordKey := range recordKeys {
+			tmpName := recordKeyName(tmpRecordKey)
+			newNameKeys[tmpName] = append(newNameKeys[tmpName], tmpRecordKey)
+		}
+		affectedNames := map[string]bool{}
+		for _, tmpRecordKey := range slices.Concat(oldRecordKeys, recordKeys) {
+			affectedNames[recordKeyName(tmpRecordKey)] = true
+		}
+		for tmpName := range affectedNames {
+			nameRecordsKey := []byte(nameRecordsKey(tmpName))
+			nameKeys, err := getKeyList(txn, nameRecordsKey)
+			if err != nil {
+				return err
+			}
+			// Keep record keys from other domains
+			nameKeys = slices.DeleteFunc(
+				nameKeys,
+				func(tmpRecordKey string) bool {
+					return slices.Contains(oldRecordKeys, tmpRecordKey) ||
+						slices.Contains(newNameKeys[tmpName], tmpRecordKey)
+				},
+			)
+			nameKeys = append(nameKeys, newNameKeys[tmpName]...)
+			if len(nameKeys) == 0 {
+				if err := txn.Delete(nameRecordsKey); err != nil {
+					return err
+				}
+				continue
+			}
+			if err := txn.Set(nameRecordsKey, []byte(strings.Join(nameKeys, ","))); err != nil {
+				return err
+			}
+		}
+		// Bump the SOA serial for the domain and its TLD
+		serialZones := []string{dns.CanonicalName(domainName)}
+		domainLabels := dns.SplitDomainName(domainName)
+		if len(domainLabels) > 1 {
+			serialZones = append(
+				serialZones,
+				dns.CanonicalName(domainLabels[len(domainLabels)-1]),
+			)
+		}
+		for _, zoneName := range serialZones {
+			if err := incrementZoneSerial(txn, zoneName); err != nil {
+				return err
+			}
+		}
 		return nil
 	})
-	return err
+	if err != nil {
+		return err
+	}
+	if unchanged {
+		slog.Debug(
+			fmt.Sprintf(
+				"skipped unchanged records for domain %s",
+				domainName,
+			),
+		)
+		return nil
+	}
+	// Log record changes for existing domains
+	if !newDomain {
+		changes.log(domainName)
+	}
+	// Update counters
+	if newDomain {
+		s.stats.domains.Add(1)
+	}
+	s.stats.records.Add(int64(len(records) - oldRecordCount))
+	return nil
+}
+
+// GetZoneSerial returns the current SOA serial for the specified zone, or 0 if the zone is unknown
+func (s *State) GetZoneSerial(zoneName string) (uint32, error) {
+	var ret uint32
+	err := s.db.View(func(txn *badger.Txn) error {
+		var err error
+		ret, err = getZoneSerial(txn, dns.CanonicalName(zoneName))
+		return err
+	})
+	if err != nil {
+		return 0, err
+	}
+	return ret, nil
+}
+
+func getZoneSerial(txn *badger.Txn, zoneName string) (uint32, error) {
+	item, err := txn.Get([]byte(fmt.Sprintf("z_%s_serial", zoneName)))
+	if err != nil {
+		if errors.Is(err, badger.ErrKeyNotFound) {
+			return 0, nil
+		}
+		return 0, err
+	}
+	val, err := item.ValueCopy(nil)
+	if err != nil {
+		return 0, err
+	}
+	serial, err := strconv.ParseUint(string(val), 10, 32)
+	if err != nil {
+		return 0, err
+	}
+	return uint32(serial), nil
+}
+
+// incrementZoneSerial bumps the SOA serial for a zone using RFC 1982 serial number arithmetic.
+// The serial wraps around at 2^32, skipping 0 since we use it to indicate an unknown zone
+func incrementZoneSerial(txn *badger.Txn, zoneName string) error {
+	serial, err := getZoneSerial(txn, zoneName)
+	if err != nil {
+		return err
+	}
+	serial++
+	if serial == 0 {
+		serial = 1
+	}
+	return txn.Set(
+		[]byte(fmt.Sprintf("z_%s_serial", zoneName)),
+		[]byte(strconv.FormatUint(uint64(serial), 10)),
+	)
 }
 
 func (s *State) LookupRecords(
@@ -290,16 +723,16 @@ func (s *State) LookupRecords(
 	err := s.db.View(func(txn *badger.Txn) error {
 		for _, recordType := range recordTypes {
 			keyPrefix := []byte(
-				fmt.Sprintf(
-					"r_%s_%s_",
-					strings.ToUpper(recordType),
-					recordName,
-				),
+				recordKeyPrefix(recordType, recordName),
 			)
 			it := txn.NewIterator(badger.DefaultIteratorOptions)
 			defer it.Close()
 			for it.Seek(keyPrefix); it.ValidForPrefix(keyPrefix); it.Next() {
 				item := it.Item()
+				// Skip keys for other names that share our prefix (such as "foo_bar" when looking up "foo")
+				if _, err := strconv.Atoi(string(item.Key()[len(keyPrefix):])); err != nil {
+					continue
+				}
 				val, err := item.ValueCopy(nil)
 				if err != nil {
 					return err
@@ -324,6 +757,138 @@ func (s *State) LookupRecords(
 	return ret, nil
 }
 
+// GetDomainRecordsByType returns all records for the specified name, grouped by record type
+func (s *State) GetDomainRecordsByType(
+	recordName string,
+) (map[string][]DomainRecord, error) {
+	ret := map[string][]DomainRecord{}
+	err := s.db.View(func(txn *badger.Txn) error {
+		recordKeys, err := getKeyList(
+			txn,
+			[]byte(nameRecordsKey(recordName)),
+		)
+		if err != nil {
+			return err
+		}
+		for _, tmpRecordKey := range recordKeys {
+			item, err := txn.Get([]byte(tmpRecordKey))
+			if err != nil {
+				// The record may have been pruned
+				if errors.Is(err, badger.ErrKeyNotFound) {
+					continue
+				}
+				return err
+			}
+			val, err := item.ValueCopy(nil)
+			if err != nil {
+				return err
+			}
+			gobBuf := bytes.NewReader(val)
+			gobDec := gob.NewDecoder(gobBuf)
+			var tmpRecord DomainRecord
+			if err := gobDec.Decode(&tmpRecord); err != nil {
+				return err
+			}
+			recordType := strings.ToUpper(tmpRecord.Type)
+			ret[recordType] = append(ret[recordType], tmpRecord)
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return ret, nil
+}
+
+// buildNameIndex populates the per-name index keys from the domain tracking keys for DBs created before they existed
+func (s *State) buildNameIndex() error {
+	return s.db.Update(func(txn *badger.Txn) error {
+		if _, err := txn.Get([]byte(nameIndexKey)); err == nil {
+			return nil
+		} else if !errors.Is(err, badger.ErrKeyNotFound) {
+			return err
+		}
+		slog.Info("database: building record name index")
+		nameKeys := map[string][]string{}
+		domainPrefix := []byte("d_")
+		it := txn.NewIterator(badger.DefaultIteratorOptions)
+		for it.Seek(domainPrefix); it.ValidForPrefix(domainPrefix); it.Next() {
+			item := it.Item()
+			if !strings.HasSuffix(string(item.Key()), "_records") {
+				continue
+			}
+			val, err := item.ValueCopy(nil)
+			if err != nil {
+				it.Close()
+				return err
+			}
+			for _, tmpRecordKey := range strings.Split(string(val), ",") {
+				if tmpRecordKey == "" {
+					continue
+				}
+				tmpName := recordKeyName(tmpRecordKey)
+				nameKeys[tmpName] = append(nameKeys[tmpName], tmpRecordKey)
+			}
+		}
+		it.Close()
+		for tmpName, tmpKeys := range nameKeys {
+			if err := txn.Set([]byte(nameRecordsKey(tmpName)), []byte(strings.Join(tmpKeys, ","))); err != nil {
+				return err
+			}
+		}
+		return txn.Set([]byte(nameIndexKey), []byte("1"))
+	})
+}
+
+// getKeyList returns the comma-separated list of keys stored at the specified key
+func getKeyList(txn *badger.Txn, key []byte) ([]string, error) {
+	item, err := txn.Get(key)
+	if err != nil {
+		if errors.Is(err, badger.ErrKeyNotFound) {
+			return nil, nil
+		}
+		return nil, err
+	}
+	val, err := item.ValueCopy(nil)
+	if err != nil {
+		return nil, err
+	}
+	var ret []string
+	for _, tmpKey := range strings.Split(string(val), ",") {
+		if tmpKey == "" {
+			continue
+		}
+		ret = append(ret, tmpKey)
+	}
+	return ret, nil
+}
+
+// nameRecordsKey returns the index key listing the record keys for the specified name
+func nameRecordsKey(recordName string) string {
+	return fmt.Sprintf("n_%s_records", strings.Trim(recordName, `.`))
+}
+
+// recordKeyName returns the record name from a record key in the form r_<type>_<name>_<index>
+func recordKeyName(recordKey string) string {
+	tmpKey := strings.TrimPrefix(recordKey, "r_")
+	if idx := strings.Index(tmpKey, "_"); idx >= 0 {
+		tmpKey = tmpKey[idx+1:]
+	}
+	if idx := strings.LastIndex(tmpKey, "_"); idx >= 0 {
+		tmpKey = tmpKey[:idx]
+	}
+	return tmpKey
+}
+
+// recordKeyPrefix returns the key prefix for records of the specified type and name
+func recordKeyPrefix(recordType string, recordName string) string {
+	return fmt.Sprintf(
+		"r_%s_%s_",
+		strings.ToUpper(recordType),
+		strings.Trim(recordName, `.`),
+	)
+}
+
 func GetState() *State {
 	return globalState
 }
@@ -0,0 +1,111 @@
+// Copyright 2024 Blink Labs Software
+//
+// Use of this source code is governed by an MIT-style
+// license that can be found in the LICENSE file or at
+// https://opensource.org/licenses/MIT.
+
+package state
+
+import (
+	"encoding/json"
+	"errors"
+	"strings"
+	"sync/atomic"
+
+	"github.com/dgraph-io/badger/v4"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+)
+
+var (
+	metricDomains = promauto.NewGaugeFunc(prometheus.GaugeOpts{
+		Name: "state_domains",
+		Help: "number of indexed domains",
+	}, func() float64 {
+		return float64(globalState.stats.domains.Load())
+	})
+	metricRecords = promauto.NewGaugeFunc(prometheus.GaugeOpts{
+		Name: "state_records",
+		Help: "number of indexed domain records",
+	}, func() float64 {
+		return float64(globalState.stats.records.Load())
+	})
+	metricDiscoveredAddresses = promauto.NewGaugeFunc(prometheus.GaugeOpts{
+		Name: "state_discovered_addresses",
+		Help: "number of discovered addresses",
+	}, func() float64 {
+		return float64(globalState.stats.discoveredAddresses.Load())
+	})
+)
+
+type Stats struct {
+	Domains             int64 `json:"domains"`
+	Records             int64 `json:"records"`
+	DiscoveredAddresses int64 `json:"discoveredAddresses"`
+}
+
+// stateStats holds counters that are maintained on writes, so that they can be read cheaply
+type stateStats struct {
+	domains             atomic.Int64
+	records             atomic.Int64
+	discoveredAddresses atomic.Int64
+}
+
+// Stats returns the current domain, record, and discovered address counts
+func (s *State) Stats() Stats {
+	return Stats{
+		Domains:             s.stats.domains.Load(),
+		Records:             s.stats.records.Load(),
+		DiscoveredAddresses: s.stats.discoveredAddresses.Load(),
+	}
+}
+
+// loadStats initializes the counters from the database. This requires a scan of the domain
+// tracking keys, so it's only done at startup
+func (s *State) loadStats() error {
+	var domains, records, discoveredAddresses int64
+	err := s.db.View(func(txn *badger.Txn) error {
+		domainPrefix := []byte("d_")
+		it := txn.NewIterator(badger.DefaultIteratorOptions)
+		defer it.Close()
+		for it.Seek(domainPrefix); it.ValidForPrefix(domainPrefix); it.Next() {
+			item := it.Item()
+			if !strings.HasSuffix(string(item.Key()), "_records") {
+				continue
+			}
+			val, err := item.ValueCopy(nil)
+			if err != nil {
+				return err
+			}
+			domains++
+			for _, tmpRecordKey := range strings.Split(string(val), ",") {
+				if tmpRecordKey == "" {
+					continue
+				}
+				records++
+			}
+		}
+		item, err := txn.Get([]byte(discoveredAddrKey))
+		if err != nil {
+			if errors.Is(err, badger.ErrKeyNotFound) {
+				return nil
+			}
+			return err
+		}
+		return item.Value(func(v []byte) error {
+			var tmpAddrs []DiscoveredAddress
+			if err := json.Unmarshal(v, &tmpAddrs); err != nil {
+				return err
+			}
+			discoveredAddresses = int64(len(tmpAddrs))
+			return nil
+		})
+	})
+	if err != nil {
+		return err
+	}
+	s.stats.domains.Store(domains)
+	s.stats.records.Store(records)
+	s.stats.discoveredAddresses.Store(discoveredAddresses)
+	return nil
+}
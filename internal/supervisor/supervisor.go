@@ -0,0 +1,59 @@
+// Copyright 2024 Blink Labs Software
+//
+// Use of this source code is governed by an MIT-style
+// license that can be found in the LICENSE file or at
+// https://opensource.org/licenses/MIT.
+
+package supervisor
+
+import (
+	"fmt"
+	"log/slog"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+)
+
+const (
+	// Backoff delays for restarting a failed subsystem
+	restartMinDelay = 1 * time.Second
+	restartMaxDelay = 1 * time.Minute
+	// Subsystems that ran at least this long before failing are restarted with the minimum delay
+	restartResetAfter = 5 * time.Minute
+)
+
+var metricRestartsTotal = promauto.NewCounterVec(prometheus.CounterOpts{
+	Name: "supervisor_restarts_total",
+	Help: "total restarts of failed subsystems",
+}, []string{"name"})
+
+// Run calls the specified function in the background, restarting it with an exponential backoff
+// whenever it returns an error. This allows a single subsystem, such as one DNS listener, to fail
+// without taking down the whole process. The function is not restarted if it returns nil
+func Run(name string, fn func() error) {
+	go func() {
+		delay := restartMinDelay
+		for {
+			startTime := time.Now()
+			err := fn()
+			if err == nil {
+				return
+			}
+			if time.Since(startTime) >= restartResetAfter {
+				delay = restartMinDelay
+			}
+			slog.Error(
+				fmt.Sprintf(
+					"%s failed: %s, restarting in %s",
+					name,
+					err,
+					delay,
+				),
+			)
+			metricRestartsTotal.WithLabelValues(name).Inc()
+			time.Sleep(delay)
+			delay = min(delay*2, restartMaxDelay)
+		}
+	}()
+}